@@ -1,38 +1,132 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"log"
+	"log/slog"
 	"net/http"
+	"os"
 
 	"merchShop/internal/config"
 	"merchShop/internal/handler"
 	"merchShop/internal/handler/mw"
+	"merchShop/internal/logging"
+	"merchShop/internal/metrics"
 	"merchShop/internal/repository"
+	"merchShop/internal/scheduler"
 	"merchShop/internal/server"
+	"merchShop/internal/tracing"
 	"merchShop/internal/usecase"
 )
 
 func main() {
-	cfg, err := config.NewConfig()
+	cfg, args, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("invalid configuration:\n%v", err)
+	}
+	slog.SetDefault(logging.New(os.Stdout, cfg.LogLevel))
+	pool := repository.PoolOptions{
+		MaxOpenConns:    cfg.DBMaxOpenConns,
+		MaxIdleConns:    cfg.DBMaxIdleConns,
+		ConnMaxLifetime: cfg.DBConnMaxLifetime,
+	}
+
+	if len(args) > 0 && args[0] == "migrate" {
+		repo, err := repository.NewPostgresRepo(cfg.DSN(), pool)
+		if err != nil {
+			log.Fatalf("failed to init repository: %v", err)
+		}
+		os.Exit(runMigrate(repo, args[1:]))
+	}
+	if len(args) > 0 {
+		log.Fatalf("unknown command %q", args[0])
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.TraceExporter)
 	if err != nil {
-		log.Fatalf("failed to load config: %v", err)
+		log.Fatalf("failed to init tracing: %v", err)
 	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			slog.Error("failed to flush traces", slog.String("error", err.Error()))
+		}
+	}()
 
-	repo, err := repository.NewPostgresRepo(cfg.DSN())
+	repo, err := repository.NewPostgresRepo(cfg.DSN(), pool)
 	if err != nil {
-		log.Fatalf("failed to init repository: %v", err)
+		slog.Error("failed to init repository", slog.String("error", err.Error()))
+		os.Exit(1)
 	}
 
+	if cfg.AutoMigrate {
+		if err := autoMigrate(repo); err != nil {
+			slog.Error("failed to migrate schema", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+	}
+
+	metrics.RegisterDB(repo.DB(), cfg.DBName)
+
 	mw.SetSecretKey([]byte(cfg.JWTSecret))
+	mw.SetAccessTokenTTL(cfg.AccessTokenTTL)
 
-	svc := usecase.NewService(repo)
-	h := handler.NewHandler(svc)
+	svc := usecase.NewService(repo, usecase.Config{
+		RefundWindow:    cfg.RefundWindow,
+		RefreshTokenTTL: cfg.RefreshTokenTTL,
+		InviteCodes:     cfg.InviteCodes,
+		UsernamePattern: cfg.UsernamePattern,
+		SignupBonus:     cfg.SignupBonus,
+		AllowanceAmount: cfg.AllowanceAmount,
+		CoinLifetime:    cfg.CoinLifetime,
+	})
+	mw.SetTokenValidator(svc)
+	h := handler.NewHandler(svc, handler.Options{
+		LegacyAuth:     cfg.LegacyAuth,
+		AuthRateLimit:  cfg.AuthRateLimit,
+		AuthRateBurst:  cfg.AuthRateBurst,
+		APIRateLimit:   cfg.APIRateLimit,
+		APIRateBurst:   cfg.APIRateBurst,
+		TrustedProxies: cfg.TrustedProxies,
+	})
 	r := server.NewRouter(h)
 
 	srv := &http.Server{
-		Addr:    ":" + cfg.ServerPort,
-		Handler: r,
+		Addr:              ":" + cfg.ServerPort,
+		Handler:           r,
+		ReadHeaderTimeout: cfg.ReadTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
 	}
 
-	server.StartHTTPServer(srv)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if cfg.AllowanceAmount > 0 {
+		go scheduler.Run(ctx, "allowance", cfg.AllowanceSchedule, func(ctx context.Context, period string) error {
+			paid, err := svc.PayAllowance(ctx, period)
+			if paid > 0 {
+				slog.InfoContext(ctx, "allowance paid", slog.String("period", period), slog.Int("users", paid))
+			}
+			return err
+		})
+	}
+	if cfg.CoinLifetime > 0 {
+		go scheduler.Run(ctx, "coin-expiry", scheduler.Hourly, func(ctx context.Context, _ string) error {
+			expired, err := svc.ExpireCoins(ctx)
+			if expired > 0 {
+				slog.InfoContext(ctx, "coins expired", slog.Int("amount", expired))
+			}
+			return err
+		})
+	}
+
+	if cfg.MetricsAddr != "" {
+		server.StartMetricsServer(cfg.MetricsAddr)
+	}
+	server.StartHTTPServer(srv, h.Drain, cfg.ShutdownDrainDelay, cfg.ShutdownTimeout)
 }
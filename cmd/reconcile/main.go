@@ -0,0 +1,60 @@
+// Command reconcile recomputes every user's balance from the ledger and from
+// the transfer/purchase history, compares both with users.coins and prints a
+// JSON report. It exits with status 2 when something needs attention.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"merchShop/internal/config"
+	"merchShop/internal/repository"
+	"merchShop/internal/usecase"
+)
+
+func main() {
+	fix := flag.Bool("fix", false, "rewrite drifted users.coins from the ledger")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s:\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprint(flag.CommandLine.Output(), config.ToolHelp)
+	}
+	flag.Parse()
+
+	cfg, err := config.NewConfig()
+	if err != nil {
+		log.Fatalf("failed to load config: %v", err)
+	}
+
+	repo, err := repository.NewPostgresRepo(cfg.DSN(), repository.PoolOptions{
+		MaxOpenConns:    cfg.DBMaxOpenConns,
+		MaxIdleConns:    cfg.DBMaxIdleConns,
+		ConnMaxLifetime: cfg.DBConnMaxLifetime,
+	})
+	if err != nil {
+		log.Fatalf("failed to init repository: %v", err)
+	}
+
+	svc := usecase.NewService(repo, usecase.Config{
+		RefundWindow: cfg.RefundWindow,
+	})
+
+	report, err := svc.Reconcile(context.Background(), *fix)
+	if err != nil {
+		log.Fatalf("reconcile failed: %v", err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		log.Fatalf("failed to write report: %v", err)
+	}
+
+	if !report.Consistent() {
+		os.Exit(2)
+	}
+}
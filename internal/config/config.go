@@ -1,39 +1,158 @@
 package config
 
 import (
+	"errors"
 	"fmt"
-	"os"
+	"log/slog"
+	"net/netip"
+	"regexp"
+	"time"
+
+	"merchShop/internal/scheduler"
+	"merchShop/internal/tracing"
+)
+
+// EnvDev is the only environment in which insecure defaults are accepted.
+const EnvDev = "dev"
+
+// Defaults that must be overridden outside of dev.
+const (
+	defaultJWTSecret  = "mysecret"
+	defaultDBPassword = "password"
+	minJWTSecretLen   = 32
 )
 
 type Config struct {
+	// Env is "dev" or "production".
+	Env string
+
 	DBHost     string
 	DBPort     string
 	DBUser     string
 	DBPassword string
 	DBName     string
 
-	ServerPort string
-	JWTSecret  string
+	DBMaxOpenConns    int
+	DBMaxIdleConns    int
+	DBConnMaxLifetime time.Duration
+
+	ServerPort   string
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+
+	// TrustedProxies are the reverse proxies allowed to report the client
+	// address in X-Forwarded-For or X-Real-IP.
+	TrustedProxies []netip.Prefix
+
+	// MetricsAddr is the listen address of the separate, unauthenticated
+	// /metrics server. Empty disables it.
+	MetricsAddr string
+
+	JWTSecret string
+
+	RefundWindow time.Duration
+
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+
+	// LegacyAuth keeps POST /api/auth, which registers unknown usernames.
+	LegacyAuth      bool
+	InviteCodes     []string
+	UsernamePattern *regexp.Regexp
+
+	SignupBonus       int
+	AllowanceAmount   int
+	AllowanceSchedule scheduler.Schedule
+	CoinLifetime      time.Duration
+
+	// AuthRateLimit is per client IP, APIRateLimit per authenticated user;
+	// both are requests per second, zero disables the limit.
+	AuthRateLimit float64
+	AuthRateBurst int
+	APIRateLimit  float64
+	APIRateBurst  int
+
+	LogLevel      slog.Level
+	TraceExporter tracing.Exporter
+
+	// AutoMigrate applies pending schema migrations at startup.
+	AutoMigrate bool
+
+	// ShutdownDrainDelay is how long /readyz fails before the server stops
+	// accepting connections; ShutdownTimeout bounds the wait for in-flight
+	// requests after that.
+	ShutdownDrainDelay time.Duration
+	ShutdownTimeout    time.Duration
 }
 
+// ToolHelp is printed by the command-line tools' -h. They call NewConfig, so
+// they need the same environment as the server to start.
+const ToolHelp = `
+Settings are read like the server's: the YAML file in CONFIG_FILE, then
+environment variables such as DATABASE_HOST and DATABASE_PASSWORD. Unless
+APP_ENV=dev, JWT_SECRET and DATABASE_PASSWORD must be real secrets, or the
+tool refuses to start. Inside the docker-compose service the environment is
+already set.
+`
+
+// NewConfig loads the configuration from defaults, the file named by
+// CONFIG_FILE and the environment. Binaries that accept configuration flags
+// use Load instead.
 func NewConfig() (*Config, error) {
-	return &Config{
-		DBHost:     getEnvOrDefault("DATABASE_HOST", "localhost"),
-		DBPort:     getEnvOrDefault("DATABASE_PORT", "5432"),
-		DBUser:     getEnvOrDefault("DATABASE_USER", "postgres"),
-		DBPassword: getEnvOrDefault("DATABASE_PASSWORD", "password"),
-		DBName:     getEnvOrDefault("DATABASE_NAME", "shop"),
-
-		ServerPort: getEnvOrDefault("SERVER_PORT", "8080"),
-		JWTSecret:  getEnvOrDefault("JWT_SECRET", "mysecret"),
-	}, nil
+	cfg, _, err := Load(nil)
+	return cfg, err
 }
 
-func getEnvOrDefault(key, def string) string {
-	if val := os.Getenv(key); val != "" {
-		return val
+// validate reports every invalid or insecure setting at once.
+func (c *Config) validate() error {
+	var errs []error
+	if c.Env != EnvDev && c.Env != "production" {
+		errs = append(errs, fmt.Errorf("env must be %q or \"production\", got %q", EnvDev, c.Env))
+	}
+	if c.DBMaxOpenConns < 0 || c.DBMaxIdleConns < 0 {
+		errs = append(errs, errors.New("database pool sizes must not be negative"))
+	}
+	if c.DBMaxOpenConns > 0 && c.DBMaxIdleConns > c.DBMaxOpenConns {
+		errs = append(errs, errors.New("database.max_idle_conns must not exceed database.max_open_conns"))
+	}
+	for name, d := range map[string]time.Duration{
+		"server.read_timeout":     c.ReadTimeout,
+		"server.write_timeout":    c.WriteTimeout,
+		"server.idle_timeout":     c.IdleTimeout,
+		"server.shutdown_timeout": c.ShutdownTimeout,
+		"auth.access_token_ttl":   c.AccessTokenTTL,
+		"auth.refresh_token_ttl":  c.RefreshTokenTTL,
+	} {
+		if d <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be positive", name))
+		}
+	}
+	if c.RefreshTokenTTL < c.AccessTokenTTL {
+		errs = append(errs, errors.New("auth.refresh_token_ttl must not be shorter than auth.access_token_ttl"))
+	}
+	if c.SignupBonus < 0 || c.AllowanceAmount < 0 {
+		errs = append(errs, errors.New("coins.signup_bonus and coins.allowance_amount must not be negative"))
+	}
+	if c.CoinLifetime < 0 || c.RefundWindow < 0 || c.ShutdownDrainDelay < 0 || c.DBConnMaxLifetime < 0 {
+		errs = append(errs, errors.New("durations must not be negative"))
+	}
+	if c.AuthRateLimit < 0 || c.APIRateLimit < 0 {
+		errs = append(errs, errors.New("rate limits must not be negative"))
+	}
+	if (c.AuthRateLimit > 0 && c.AuthRateBurst < 1) || (c.APIRateLimit > 0 && c.APIRateBurst < 1) {
+		errs = append(errs, errors.New("rate limit bursts must be at least 1"))
+	}
+
+	if c.Env != EnvDev {
+		if c.JWTSecret == defaultJWTSecret || len(c.JWTSecret) < minJWTSecretLen {
+			errs = append(errs, fmt.Errorf("auth.jwt_secret must be set to a random value of at least %d bytes", minJWTSecretLen))
+		}
+		if c.DBPassword == defaultDBPassword {
+			errs = append(errs, errors.New("database.password must not be the default"))
+		}
 	}
-	return def
+	return errors.Join(errs...)
 }
 
 func (c *Config) DSN() string {
@@ -0,0 +1,100 @@
+package config
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+func TestLoadLayers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(path, []byte(`
+env: production
+database:
+  password: from-file
+  max_open_conns: 50
+auth:
+  jwt_secret: `+testSecret+`
+  access_token_ttl: 10m
+  invite_codes: [alpha, beta]
+coins:
+  signup_bonus: 500
+`), 0o600)
+	assert.NoError(t, err)
+
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("SIGNUP_BONUS", "700")
+	t.Setenv("ACCESS_TOKEN_TTL", "20m")
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8, 192.168.1.5")
+
+	cfg, args, err := Load([]string{"-auth.access_token_ttl=5m", "migrate", "up"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"migrate", "up"}, args)
+	assert.Equal(t, "localhost", cfg.DBHost, "default")
+	assert.Equal(t, "from-file", cfg.DBPassword, "file")
+	assert.Equal(t, 50, cfg.DBMaxOpenConns, "file")
+	assert.Equal(t, []string{"alpha", "beta"}, cfg.InviteCodes, "file list")
+	assert.Equal(t, 700, cfg.SignupBonus, "env over file")
+	assert.Equal(t, 5*time.Minute, cfg.AccessTokenTTL, "flag over env")
+	assert.Equal(t, []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("192.168.1.5/32")}, cfg.TrustedProxies)
+}
+
+func TestLoadReportsAllErrors(t *testing.T) {
+	t.Setenv("APP_ENV", "production")
+	t.Setenv("ACCESS_TOKEN_TTL", "soon")
+	t.Setenv("SIGNUP_BONUS", "many")
+
+	_, _, err := Load(nil)
+	if assert.Error(t, err) {
+		msg := err.Error()
+		assert.Contains(t, msg, "invalid auth.access_token_ttl (ACCESS_TOKEN_TTL)")
+		assert.Contains(t, msg, "invalid coins.signup_bonus (SIGNUP_BONUS)")
+		assert.Contains(t, msg, "auth.jwt_secret must be set", "validated alongside parse errors")
+		assert.Contains(t, msg, "database.password must not be the default", "validated alongside parse errors")
+		assert.NotContains(t, msg, "auth.access_token_ttl must be positive", "unparsed value is not validated again")
+	}
+
+	t.Setenv("ACCESS_TOKEN_TTL", "")
+	t.Setenv("SIGNUP_BONUS", "-1")
+	_, _, err = Load(nil)
+	if assert.Error(t, err) {
+		msg := err.Error()
+		assert.Contains(t, msg, "auth.jwt_secret must be set", "default secret outside dev")
+		assert.Contains(t, msg, "database.password must not be the default", "default password outside dev")
+		assert.Contains(t, msg, "coins.signup_bonus and coins.allowance_amount must not be negative")
+	}
+
+	t.Setenv("APP_ENV", "dev")
+	t.Setenv("SIGNUP_BONUS", "")
+	_, _, err = Load(nil)
+	assert.NoError(t, err, "insecure defaults are fine in dev")
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte("auth:\n  jwt_secrte: x\n"), 0o600))
+	_, _, err = Load([]string{"-config", path})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "auth.jwt_secrte")
+	}
+}
+
+func TestLoadEmptyEnv(t *testing.T) {
+	t.Setenv("APP_ENV", "dev")
+	t.Setenv("METRICS_ADDR", "")
+	t.Setenv("REGISTRATION_INVITE_CODES", "")
+	t.Setenv("SIGNUP_BONUS", "")
+	t.Setenv("ACCESS_TOKEN_TTL", "")
+
+	cfg, _, err := Load([]string{})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "", cfg.MetricsAddr, "empty string overrides the localhost:9090 default")
+		assert.Empty(t, cfg.InviteCodes)
+		assert.Equal(t, 1000, cfg.SignupBonus, "typed settings treat empty as unset")
+		assert.Equal(t, 15*time.Minute, cfg.AccessTokenTTL, "typed settings treat empty as unset")
+	}
+}
@@ -0,0 +1,298 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"merchShop/internal/logging"
+	"merchShop/internal/scheduler"
+	"merchShop/internal/tracing"
+)
+
+// setting is one configuration value. key is its YAML path and flag name,
+// env the environment variable that overrides the file.
+type setting struct {
+	key   string
+	env   string
+	def   string
+	usage string
+	set   func(string) error
+}
+
+// allowsEmpty reports whether "" is a valid value for s, as it is for
+// strings and lists but not for numbers or durations. It may overwrite the
+// field; Load sets every field afterwards anyway.
+func (s setting) allowsEmpty() bool {
+	return s.set("") == nil
+}
+
+func settings(c *Config) []setting {
+	return []setting{
+		{"env", "APP_ENV", "production", "dev or production; insecure defaults are rejected outside dev", stringVar(&c.Env)},
+
+		{"database.host", "DATABASE_HOST", "localhost", "Postgres host", stringVar(&c.DBHost)},
+		{"database.port", "DATABASE_PORT", "5432", "Postgres port", stringVar(&c.DBPort)},
+		{"database.user", "DATABASE_USER", "postgres", "Postgres user", stringVar(&c.DBUser)},
+		{"database.password", "DATABASE_PASSWORD", defaultDBPassword, "Postgres password", stringVar(&c.DBPassword)},
+		{"database.name", "DATABASE_NAME", "shop", "Postgres database", stringVar(&c.DBName)},
+		{"database.max_open_conns", "DATABASE_MAX_OPEN_CONNS", "25", "connection pool size, 0 for unlimited", intVar(&c.DBMaxOpenConns)},
+		{"database.max_idle_conns", "DATABASE_MAX_IDLE_CONNS", "10", "idle connections kept in the pool", intVar(&c.DBMaxIdleConns)},
+		{"database.conn_max_lifetime", "DATABASE_CONN_MAX_LIFETIME", "30m", "recycle connections after this long, 0 to keep them", durationVar(&c.DBConnMaxLifetime)},
+		{"database.auto_migrate", "AUTO_MIGRATE", "false", "apply pending schema migrations at startup", boolVar(&c.AutoMigrate)},
+
+		{"server.port", "SERVER_PORT", "8080", "HTTP port", stringVar(&c.ServerPort)},
+		{"server.read_timeout", "SERVER_READ_TIMEOUT", "10s", "maximum time to read a request", durationVar(&c.ReadTimeout)},
+		{"server.write_timeout", "SERVER_WRITE_TIMEOUT", "30s", "maximum time to write a response", durationVar(&c.WriteTimeout)},
+		{"server.idle_timeout", "SERVER_IDLE_TIMEOUT", "2m", "keep-alive connection idle timeout", durationVar(&c.IdleTimeout)},
+		{"server.shutdown_drain_delay", "SHUTDOWN_DRAIN_DELAY", "5s", "how long /readyz fails before shutdown", durationVar(&c.ShutdownDrainDelay)},
+		{"server.shutdown_timeout", "SHUTDOWN_TIMEOUT", "10s", "how long to wait for in-flight requests on shutdown", durationVar(&c.ShutdownTimeout)},
+		{"server.trusted_proxies", "TRUSTED_PROXIES", "", "comma-separated proxy IPs or CIDRs whose X-Forwarded-For is believed", prefixListVar(&c.TrustedProxies)},
+		{"server.metrics_addr", "METRICS_ADDR", "localhost:9090", "internal listen address for /metrics, empty to disable", stringVar(&c.MetricsAddr)},
+
+		{"auth.jwt_secret", "JWT_SECRET", defaultJWTSecret, "JWT signing key", stringVar(&c.JWTSecret)},
+		{"auth.access_token_ttl", "ACCESS_TOKEN_TTL", "15m", "access token lifetime", durationVar(&c.AccessTokenTTL)},
+		{"auth.refresh_token_ttl", "REFRESH_TOKEN_TTL", "720h", "refresh token lifetime", durationVar(&c.RefreshTokenTTL)},
+		{"auth.legacy_auth", "LEGACY_AUTH_ENABLED", "true", "keep POST /api/auth with auto-registration", boolVar(&c.LegacyAuth)},
+		{"auth.invite_codes", "REGISTRATION_INVITE_CODES", "", "comma-separated invite codes required to register", listVar(&c.InviteCodes)},
+		{"auth.username_pattern", "REGISTRATION_USERNAME_PATTERN", "", "regular expression new usernames must match", regexpVar(&c.UsernamePattern)},
+
+		{"coins.signup_bonus", "SIGNUP_BONUS", "1000", "coins issued to every new account", intVar(&c.SignupBonus)},
+		{"coins.allowance_amount", "ALLOWANCE_AMOUNT", "0", "coins credited to every user each period, 0 to disable", intVar(&c.AllowanceAmount)},
+		{"coins.allowance_schedule", "ALLOWANCE_SCHEDULE", string(scheduler.Monthly), "allowance period: @hourly, @daily, @weekly or @monthly", scheduleVar(&c.AllowanceSchedule)},
+		{"coins.lifetime", "COIN_LIFETIME", "8760h", "how long credited coins stay spendable, 0 to disable expiry", durationVar(&c.CoinLifetime)},
+		{"coins.refund_window", "REFUND_WINDOW", "336h", "how long after a purchase it can be returned", durationVar(&c.RefundWindow)},
+
+		{"rate_limit.auth_rps", "RATE_LIMIT_AUTH_RPS", "5", "login and registration requests per second per IP, 0 to disable", floatVar(&c.AuthRateLimit)},
+		{"rate_limit.auth_burst", "RATE_LIMIT_AUTH_BURST", "10", "burst for rate_limit.auth_rps", intVar(&c.AuthRateBurst)},
+		{"rate_limit.api_rps", "RATE_LIMIT_API_RPS", "20", "authenticated requests per second per user, 0 to disable", floatVar(&c.APIRateLimit)},
+		{"rate_limit.api_burst", "RATE_LIMIT_API_BURST", "40", "burst for rate_limit.api_rps", intVar(&c.APIRateBurst)},
+
+		{"log.level", "LOG_LEVEL", "info", "debug, info, warn or error", levelVar(&c.LogLevel)},
+		{"tracing.exporter", "TRACING_EXPORTER", string(tracing.ExporterNone), "none, stdout or otlp", exporterVar(&c.TraceExporter)},
+	}
+}
+
+// Load builds the configuration in layers: built-in defaults, then the YAML
+// file given by -config or CONFIG_FILE, then environment variables, then
+// command-line flags. Every setting is a flag named after its YAML key, e.g.
+// -auth.access_token_ttl=5m. It returns the arguments left after the flags,
+// and all problems found at once.
+func Load(args []string) (*Config, []string, error) {
+	c := &Config{}
+	table := settings(c)
+	values := make(map[string]string, len(table))
+	for _, s := range table {
+		values[s.key] = s.def
+	}
+
+	fs := flag.NewFlagSet("avito-shop", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "YAML configuration file")
+	flags := make(map[string]*string, len(table))
+	for _, s := range table {
+		flags[s.key] = fs.String(s.key, "", fmt.Sprintf("%s (env %s, default %q)", s.usage, s.env, s.def))
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	var errs []error
+	if *configFile != "" {
+		if err := readFile(*configFile, values); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, s := range table {
+		// A set but empty variable counts, so METRICS_ADDR= can switch the
+		// listener off. Typed settings have no empty value and keep theirs.
+		if v, ok := os.LookupEnv(s.env); ok && (v != "" || s.allowsEmpty()) {
+			values[s.key] = v
+		}
+	}
+	fs.Visit(func(f *flag.Flag) {
+		if p, ok := flags[f.Name]; ok {
+			values[f.Name] = *p
+		}
+	})
+
+	for _, s := range table {
+		if err := s.set(values[s.key]); err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s (%s): %w", s.key, s.env, err))
+			// Validate the rest against the default rather than a zero
+			// value, so one typo is not reported twice.
+			_ = s.set(s.def)
+		}
+	}
+	errs = append(errs, c.validate())
+	if err := errors.Join(errs...); err != nil {
+		return nil, nil, err
+	}
+	return c, fs.Args(), nil
+}
+
+// readFile merges the YAML file at path into values. Unknown keys are
+// reported so a typo does not silently fall back to a default.
+func readFile(path string, values map[string]string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("cannot read config file: %w", err)
+	}
+	var doc map[string]interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("cannot parse config file %s: %w", path, err)
+	}
+
+	flat := make(map[string]string)
+	flatten("", doc, flat)
+	var unknown []string
+	for k, v := range flat {
+		if _, ok := values[k]; !ok {
+			unknown = append(unknown, k)
+			continue
+		}
+		values[k] = v
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown keys in config file %s: %s", path, strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// flatten turns nested YAML mappings into dotted keys. Sequences become
+// comma-separated lists.
+func flatten(prefix string, node map[string]interface{}, out map[string]string) {
+	for k, v := range node {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+		switch v := v.(type) {
+		case map[string]interface{}:
+			flatten(key, v, out)
+		case []interface{}:
+			items := make([]string, len(v))
+			for i, it := range v {
+				items[i] = fmt.Sprint(it)
+			}
+			out[key] = strings.Join(items, ",")
+		case nil:
+			out[key] = ""
+		default:
+			out[key] = fmt.Sprint(v)
+		}
+	}
+}
+
+func stringVar(p *string) func(string) error {
+	return func(v string) error {
+		*p = v
+		return nil
+	}
+}
+
+func intVar(p *int) func(string) error {
+	return func(v string) (err error) {
+		*p, err = strconv.Atoi(v)
+		return err
+	}
+}
+
+func floatVar(p *float64) func(string) error {
+	return func(v string) (err error) {
+		*p, err = strconv.ParseFloat(v, 64)
+		return err
+	}
+}
+
+func boolVar(p *bool) func(string) error {
+	return func(v string) (err error) {
+		*p, err = strconv.ParseBool(v)
+		return err
+	}
+}
+
+func durationVar(p *time.Duration) func(string) error {
+	return func(v string) (err error) {
+		*p, err = time.ParseDuration(v)
+		return err
+	}
+}
+
+// listVar splits a comma-separated value, dropping blanks.
+func listVar(p *[]string) func(string) error {
+	return func(v string) error {
+		*p = nil
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*p = append(*p, item)
+			}
+		}
+		return nil
+	}
+}
+
+// prefixListVar parses a comma-separated list of CIDRs; a bare IP stands for
+// a single address.
+func prefixListVar(p *[]netip.Prefix) func(string) error {
+	return func(v string) error {
+		var items []string
+		if err := listVar(&items)(v); err != nil {
+			return err
+		}
+		*p = nil
+		for _, item := range items {
+			prefix, err := netip.ParsePrefix(item)
+			if err != nil {
+				addr, addrErr := netip.ParseAddr(item)
+				if addrErr != nil {
+					return err
+				}
+				prefix = netip.PrefixFrom(addr, addr.BitLen())
+			}
+			*p = append(*p, prefix.Masked())
+		}
+		return nil
+	}
+}
+
+func regexpVar(p **regexp.Regexp) func(string) error {
+	return func(v string) (err error) {
+		*p = nil
+		if v != "" {
+			*p, err = regexp.Compile(v)
+		}
+		return err
+	}
+}
+
+func scheduleVar(p *scheduler.Schedule) func(string) error {
+	return func(v string) (err error) {
+		*p, err = scheduler.ParseSchedule(v)
+		return err
+	}
+}
+
+func levelVar(p *slog.Level) func(string) error {
+	return func(v string) (err error) {
+		*p, err = logging.ParseLevel(v)
+		return err
+	}
+}
+
+func exporterVar(p *tracing.Exporter) func(string) error {
+	return func(v string) (err error) {
+		*p, err = tracing.ParseExporter(v)
+		return err
+	}
+}
@@ -0,0 +1,18 @@
+package domain
+
+import "errors"
+
+// Errors the repository returns when a business rule is violated inside a
+// transaction, so callers can tell them apart from infrastructure failures.
+var (
+	ErrOutOfStock         = errors.New("out of stock")
+	ErrUnknownItem        = errors.New("unknown item")
+	ErrItemRetired        = errors.New("item is no longer available")
+	ErrNotEnoughCoins     = errors.New("not enough coins")
+	ErrNothingToRefund    = errors.New("nothing left to refund for this purchase")
+	ErrRefreshTokenReused = errors.New("refresh token was already used")
+	ErrAccountFrozen      = errors.New("account is frozen")
+	// ErrMissingLedgerAccount means a wallet predates the ledger and was not
+	// migrated, so its balance cannot be derived from the ledger.
+	ErrMissingLedgerAccount = errors.New("user has no ledger account")
+)
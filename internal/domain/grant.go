@@ -0,0 +1,8 @@
+package domain
+
+// Grant mints Amount new coins to a user from the issuance account.
+type Grant struct {
+	UserID int
+	Amount int
+	Reason string
+}
@@ -0,0 +1,9 @@
+package domain
+
+// IdempotencyRecord is what is stored for an Idempotency-Key. StatusCode is
+// zero while the original request is still being processed.
+type IdempotencyRecord struct {
+	RequestHash string
+	StatusCode  int
+	Body        []byte
+}
@@ -0,0 +1,75 @@
+package domain
+
+import (
+	"strconv"
+	"time"
+)
+
+// System ledger accounts. Every user wallet has its own account, see UserAccount.
+const (
+	AccountIssuance    = "system:issuance"
+	AccountShopRevenue = "shop:revenue"
+	AccountExpired     = "system:expired"
+	AccountForfeited   = "system:forfeited"
+)
+
+const (
+	EntrySignup    = "signup"
+	EntryTransfer  = "transfer"
+	EntryPurchase  = "purchase"
+	EntryRefund    = "refund"
+	EntryGrant     = "grant"
+	EntryAllowance = "allowance"
+	EntryExpiry    = "expiry"
+	EntryForfeit   = "forfeit"
+)
+
+func UserAccount(userID int) string {
+	return "user:" + strconv.Itoa(userID)
+}
+
+// Posting moves Amount coins into (positive) or out of (negative) an account.
+type Posting struct {
+	Account string
+	Amount  int
+}
+
+// LedgerEntry is a single balanced movement: its postings always sum to zero.
+type LedgerEntry struct {
+	ID        int
+	Kind      string
+	ActorID   int
+	Postings  []Posting
+	CreatedAt time.Time
+	// RefundOf is, for a refund, the purchase entry whose coins are returned.
+	// They keep the dates of the lots that purchase spent.
+	RefundOf int
+}
+
+// Transfer builds the postings that move amount coins from one account to another.
+func Transfer(from, to string, amount int) []Posting {
+	return []Posting{
+		{Account: from, Amount: -amount},
+		{Account: to, Amount: amount},
+	}
+}
+
+// BalanceDrift describes a user whose cached users.coins differs from the
+// balance derived from the ledger.
+type BalanceDrift struct {
+	UserID   int
+	Username string
+	Cached   int
+	Ledger   int
+}
+
+// UserBalance is a user's balance computed three ways: the cached projection,
+// the ledger and the business history (signup grant, transfers, purchases and
+// refunds).
+type UserBalance struct {
+	UserID   int
+	Username string
+	Cached   int
+	Ledger   int
+	History  int
+}
@@ -0,0 +1,13 @@
+package domain
+
+import "time"
+
+// CoinLot is a batch of coins credited to a user at CreatedAt. Remaining is
+// what is left of it after spending.
+type CoinLot struct {
+	ID        int
+	UserID    int
+	Amount    int
+	Remaining int
+	CreatedAt time.Time
+}
@@ -0,0 +1,36 @@
+package domain
+
+import "time"
+
+// OrderLine is one position of a checkout. UnitPrice is filled in by the
+// service from the catalog when the order is priced; the purchase
+// transaction records the price it actually charged.
+type OrderLine struct {
+	ItemName  string
+	Quantity  int
+	UnitPrice int
+}
+
+// Purchase is a persisted order line with the price that was actually paid.
+type Purchase struct {
+	ID        int
+	UserID    int
+	ItemName  string
+	Quantity  int
+	UnitPrice int
+	// RefundedQuantity is the number of units already returned.
+	RefundedQuantity int
+	CreatedAt        time.Time
+}
+
+// Refund is the audit record of returned units of a purchase.
+type Refund struct {
+	ID            int
+	PurchaseID    int
+	UserID        int
+	Quantity      int
+	Amount        int
+	AdminOverride bool
+	RefundedBy    int
+	CreatedAt     time.Time
+}
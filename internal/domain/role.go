@@ -0,0 +1,44 @@
+package domain
+
+// Role decides what an authenticated user may do beyond spending their own
+// coins. Every account starts as an employee.
+type Role string
+
+const (
+	RoleEmployee Role = "employee"
+	RoleManager  Role = "manager"
+	RoleAdmin    Role = "admin"
+)
+
+// Permission names a guarded group of operations.
+type Permission string
+
+const (
+	PermManageCatalog Permission = "catalog:manage"
+	PermRefundAny     Permission = "purchases:refund-any"
+	PermManageLedger  Permission = "ledger:manage"
+	PermManageUsers   Permission = "users:manage"
+	PermGrantCoins    Permission = "coins:grant"
+)
+
+var rolePermissions = map[Role][]Permission{
+	RoleEmployee: nil,
+	RoleManager:  {PermManageCatalog, PermRefundAny},
+	RoleAdmin:    {PermManageCatalog, PermRefundAny, PermManageLedger, PermManageUsers, PermGrantCoins},
+}
+
+// Valid reports whether r is one of the known roles.
+func (r Role) Valid() bool {
+	_, ok := rolePermissions[r]
+	return ok
+}
+
+// Can reports whether the role grants p. Unknown roles grant nothing.
+func (r Role) Can(p Permission) bool {
+	for _, granted := range rolePermissions[r] {
+		if granted == p {
+			return true
+		}
+	}
+	return false
+}
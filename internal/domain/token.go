@@ -0,0 +1,16 @@
+package domain
+
+import "time"
+
+// RefreshToken is stored hashed; the plain value is only known to the client.
+// Tokens rotated from the same login share a FamilyID so that reuse of an
+// already rotated token can revoke the whole chain.
+type RefreshToken struct {
+	ID        int
+	UserID    int
+	TokenHash string
+	FamilyID  string
+	ExpiresAt time.Time
+	RevokedAt *time.Time
+	CreatedAt time.Time
+}
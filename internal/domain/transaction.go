@@ -2,10 +2,30 @@ package domain
 
 import "time"
 
+// CoinTransaction is a transfer between users. FromUserID is zero for coins
+// granted by the company rather than sent by a colleague.
 type CoinTransaction struct {
 	ID         int
 	FromUserID int
 	ToUserID   int
 	Amount     int
+	Memo       string
 	CreatedAt  time.Time
 }
+
+const (
+	DirectionSent     = "sent"
+	DirectionReceived = "received"
+)
+
+// TransactionFilter selects a page of a user's coin history. Zero values mean
+// "no restriction"; BeforeID is the keyset cursor (exclusive).
+type TransactionFilter struct {
+	UserID         int
+	Direction      string
+	CounterpartyID int
+	From           time.Time
+	To             time.Time
+	BeforeID       int
+	Limit          int
+}
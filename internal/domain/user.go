@@ -7,8 +7,26 @@ type User struct {
 	Username     string
 	PasswordHash string
 	Coins        int
+	Role         Role
+	Status       UserStatus
 }
 
+// UserStatus controls whether an account may use its wallet at all.
+type UserStatus string
+
+const (
+	StatusActive UserStatus = "active"
+	// StatusFrozen blocks logging in, sending, receiving and buying until
+	// an admin unfreezes the account.
+	StatusFrozen UserStatus = "frozen"
+	// StatusDeleted marks an anonymised account. The row is kept so the
+	// ledger and other users' histories stay intact.
+	StatusDeleted UserStatus = "deleted"
+)
+
+// DeletedUsernamePrefix starts the placeholder username of a deleted account.
+const DeletedUsernamePrefix = "deleted-"
+
 type UserInventory struct {
 	ID        int
 	UserID    int
@@ -0,0 +1,273 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"merchShop/internal/domain"
+	"merchShop/internal/handler/mw"
+	"merchShop/internal/usecase"
+)
+
+func (h *Handler) adminListItems(w http.ResponseWriter, r *http.Request) {
+	items, err := h.service.ListAllItems(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	writeJSON(w, items)
+}
+
+type createItemRequest struct {
+	Name        string `json:"name"`
+	Price       int    `json:"price"`
+	Stock       int    `json:"stock"`
+	Description string `json:"description"`
+}
+
+func (h *Handler) adminCreateItem(w http.ResponseWriter, r *http.Request) {
+	var req createItemRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"errors":"bad request"}`, http.StatusBadRequest)
+		return
+	}
+	if err := h.service.CreateItem(r.Context(), req.Name, req.Price, req.Stock, req.Description); err != nil {
+		writeCatalogError(w, r, err)
+		return
+	}
+	writeJSONStatus(w, http.StatusCreated, map[string]string{"status": "ok"})
+}
+
+type updatePriceRequest struct {
+	Price int `json:"price"`
+}
+
+func (h *Handler) adminUpdateItemPrice(w http.ResponseWriter, r *http.Request) {
+	var req updatePriceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"errors":"bad request"}`, http.StatusBadRequest)
+		return
+	}
+	if err := h.service.UpdateItemPrice(r.Context(), chi.URLParam(r, "item"), req.Price); err != nil {
+		writeCatalogError(w, r, err)
+		return
+	}
+	writeJSON(w, map[string]string{"status": "ok"})
+}
+
+type updateStockRequest struct {
+	Stock int `json:"stock"`
+}
+
+func (h *Handler) adminUpdateItemStock(w http.ResponseWriter, r *http.Request) {
+	var req updateStockRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"errors":"bad request"}`, http.StatusBadRequest)
+		return
+	}
+	if err := h.service.UpdateItemStock(r.Context(), chi.URLParam(r, "item"), req.Stock); err != nil {
+		writeCatalogError(w, r, err)
+		return
+	}
+	writeJSON(w, map[string]string{"status": "ok"})
+}
+
+func (h *Handler) adminRetireItem(w http.ResponseWriter, r *http.Request) {
+	if err := h.service.RetireItem(r.Context(), chi.URLParam(r, "item")); err != nil {
+		writeCatalogError(w, r, err)
+		return
+	}
+	writeJSON(w, map[string]string{"status": "ok"})
+}
+
+func (h *Handler) adminRefundPurchase(w http.ResponseWriter, r *http.Request) {
+	adminID := mw.MustGetUserID(r.Context())
+	purchaseID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, `{"errors":"invalid purchase id"}`, http.StatusBadRequest)
+		return
+	}
+	var req refundRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, `{"errors":"bad request"}`, http.StatusBadRequest)
+			return
+		}
+	}
+
+	refund, err := h.service.AdminRefundPurchase(r.Context(), adminID, purchaseID, req.Quantity)
+	if err != nil {
+		writeRefundError(w, r, err)
+		return
+	}
+	writeJSON(w, refund)
+}
+
+func (h *Handler) adminVerifyLedger(w http.ResponseWriter, r *http.Request) {
+	report, err := h.service.VerifyLedger(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	writeJSON(w, report)
+}
+
+func (h *Handler) adminRebuildBalances(w http.ResponseWriter, r *http.Request) {
+	repaired, err := h.service.RebuildBalances(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	writeJSON(w, map[string]int{"repaired": repaired})
+}
+
+type setRoleRequest struct {
+	Role string `json:"role"`
+}
+
+func (h *Handler) adminSetUserRole(w http.ResponseWriter, r *http.Request) {
+	adminID := mw.MustGetUserID(r.Context())
+	var req setRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"errors":"bad request"}`, http.StatusBadRequest)
+		return
+	}
+	err := h.service.SetUserRole(r.Context(), adminID, chi.URLParam(r, "username"), domain.Role(req.Role))
+	if err != nil {
+		switch err {
+		case usecase.ErrUserNotFound:
+			http.Error(w, `{"errors":"user not found"}`, http.StatusNotFound)
+		case usecase.ErrInvalidRole, usecase.ErrChangeOwnRole:
+			http.Error(w, `{"errors":"`+err.Error()+`"}`, http.StatusBadRequest)
+		default:
+			writeInternalError(w, r, err)
+		}
+		return
+	}
+	writeJSON(w, map[string]string{"status": "ok"})
+}
+
+type grantRequest struct {
+	Grants []usecase.GrantRequest `json:"grants"`
+}
+
+// maxGrantUpload bounds the size of an uploaded grants CSV.
+const maxGrantUpload = 1 << 20
+
+// adminGrantCoins accepts either a JSON body or a CSV file, sent as text/csv
+// or as the "file" field of a multipart form. For CSV the "reason" query or
+// form value is used for rows without their own reason.
+func (h *Handler) adminGrantCoins(w http.ResponseWriter, r *http.Request) {
+	adminID := mw.MustGetUserID(r.Context())
+
+	var grants []usecase.GrantRequest
+	var err error
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "text/csv":
+		grants, err = usecase.ParseGrantsCSV(http.MaxBytesReader(w, r.Body, maxGrantUpload), r.URL.Query().Get("reason"))
+	case "multipart/form-data":
+		if err = r.ParseMultipartForm(maxGrantUpload); err != nil {
+			http.Error(w, `{"errors":"bad request"}`, http.StatusBadRequest)
+			return
+		}
+		file, _, ferr := r.FormFile("file")
+		if ferr != nil {
+			http.Error(w, `{"errors":"file is required"}`, http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		grants, err = usecase.ParseGrantsCSV(file, r.FormValue("reason"))
+	default:
+		var req grantRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, `{"errors":"bad request"}`, http.StatusBadRequest)
+			return
+		}
+		grants = req.Grants
+	}
+	if err != nil {
+		http.Error(w, `{"errors":"`+jsonEscape(err.Error())+`"}`, http.StatusBadRequest)
+		return
+	}
+
+	res, err := h.service.GrantCoins(r.Context(), adminID, grants)
+	if err != nil {
+		switch {
+		case errors.Is(err, usecase.ErrEmptyGrant), errors.Is(err, usecase.ErrTooManyGrants),
+			errors.Is(err, usecase.ErrInvalidGrantAmount), errors.Is(err, usecase.ErrReasonRequired),
+			errors.Is(err, usecase.ErrMemoTooLong), errors.Is(err, usecase.ErrUnknownRecipient),
+			errors.Is(err, usecase.ErrRecipientFrozen):
+			http.Error(w, `{"errors":"`+jsonEscape(err.Error())+`"}`, http.StatusBadRequest)
+		default:
+			writeInternalError(w, r, err)
+		}
+		return
+	}
+	writeJSON(w, res)
+}
+
+// jsonEscape makes user-supplied text (usernames, CSV fragments) safe to
+// embed in a hand-written JSON error body.
+func jsonEscape(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b[1 : len(b)-1])
+}
+
+func (h *Handler) adminFreezeUser(w http.ResponseWriter, r *http.Request) {
+	h.adminSetUserStatus(w, r, domain.StatusFrozen)
+}
+
+func (h *Handler) adminUnfreezeUser(w http.ResponseWriter, r *http.Request) {
+	h.adminSetUserStatus(w, r, domain.StatusActive)
+}
+
+func (h *Handler) adminSetUserStatus(w http.ResponseWriter, r *http.Request, status domain.UserStatus) {
+	adminID := mw.MustGetUserID(r.Context())
+	if err := h.service.SetUserStatus(r.Context(), adminID, chi.URLParam(r, "username"), status); err != nil {
+		switch err {
+		case usecase.ErrUserNotFound:
+			http.Error(w, `{"errors":"user not found"}`, http.StatusNotFound)
+		case usecase.ErrFreezeSelf:
+			http.Error(w, `{"errors":"`+err.Error()+`"}`, http.StatusBadRequest)
+		default:
+			writeInternalError(w, r, err)
+		}
+		return
+	}
+	writeJSON(w, map[string]string{"status": string(status)})
+}
+
+func (h *Handler) adminDeleteUser(w http.ResponseWriter, r *http.Request) {
+	adminID := mw.MustGetUserID(r.Context())
+	if err := h.service.DeleteUser(r.Context(), adminID, chi.URLParam(r, "username")); err != nil {
+		switch err {
+		case usecase.ErrUserNotFound:
+			http.Error(w, `{"errors":"user not found"}`, http.StatusNotFound)
+		case usecase.ErrDeleteSelf:
+			http.Error(w, `{"errors":"`+err.Error()+`"}`, http.StatusBadRequest)
+		default:
+			writeInternalError(w, r, err)
+		}
+		return
+	}
+	writeJSON(w, map[string]string{"status": "deleted"})
+}
+
+func writeCatalogError(w http.ResponseWriter, r *http.Request, err error) {
+	switch err {
+	case usecase.ErrUnknownItem:
+		http.Error(w, `{"errors":"unknown item"}`, http.StatusNotFound)
+	case usecase.ErrItemExists:
+		http.Error(w, `{"errors":"`+err.Error()+`"}`, http.StatusConflict)
+	case usecase.ErrInvalidItemName, usecase.ErrInvalidPrice, usecase.ErrInvalidStock:
+		http.Error(w, `{"errors":"`+err.Error()+`"}`, http.StatusBadRequest)
+	default:
+		writeInternalError(w, r, err)
+	}
+}
@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// readinessTimeout bounds the dependency checks so a hung database fails
+// the probe instead of blocking it.
+const readinessTimeout = 2 * time.Second
+
+// Drain makes /readyz fail from now on, so load balancers stop sending new
+// requests before the server shuts down.
+func (h *Handler) Drain() {
+	h.draining.Store(true)
+}
+
+// healthz reports that the process is up and serving HTTP. It checks no
+// dependencies, so a database outage does not get the process restarted.
+func (h *Handler) healthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]string{"status": "ok"})
+}
+
+// readyz reports whether this instance should receive traffic. Failed checks
+// are logged; the response only names them.
+func (h *Handler) readyz(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]string{}
+	ready := true
+	if h.draining.Load() {
+		checks["shutdown"] = "draining"
+		ready = false
+	} else {
+		ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+		defer cancel()
+		for name, err := range h.service.Readiness(ctx) {
+			if err != nil {
+				slog.WarnContext(r.Context(), "readiness check failed",
+					slog.String("check", name), slog.String("error", err.Error()))
+				checks[name] = "failing"
+				ready = false
+				continue
+			}
+			checks[name] = "ok"
+		}
+	}
+
+	if !ready {
+		writeJSONStatus(w, http.StatusServiceUnavailable, map[string]interface{}{"status": "unavailable", "checks": checks})
+		return
+	}
+	writeJSON(w, map[string]interface{}{"status": "ok", "checks": checks})
+}
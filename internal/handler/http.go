@@ -2,35 +2,117 @@ package handler
 
 import (
 	"encoding/json"
+	"log/slog"
 	"net/http"
+	"net/netip"
+	"strconv"
+	"sync/atomic"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 
+	"merchShop/internal/domain"
 	"merchShop/internal/handler/mw"
+	"merchShop/internal/logging"
 	"merchShop/internal/usecase"
 )
 
 type Handler struct {
-	service *usecase.Service
+	service  *usecase.Service
+	opts     Options
+	draining atomic.Bool
 }
 
-func NewHandler(service *usecase.Service) *Handler {
-	return &Handler{service: service}
+// Options switches optional parts of the API on and off.
+type Options struct {
+	// LegacyAuth mounts POST /api/auth, which registers unknown usernames
+	// instead of rejecting them.
+	LegacyAuth bool
+	// AuthRateLimit and AuthRateBurst limit login and registration attempts
+	// per client IP. A zero rate disables the limit.
+	AuthRateLimit float64
+	AuthRateBurst int
+	// APIRateLimit and APIRateBurst limit authenticated requests per user.
+	APIRateLimit float64
+	APIRateBurst int
+	// TrustedProxies may set X-Forwarded-For and X-Real-IP for the per-IP
+	// limit; requests from anywhere else are keyed by their remote address.
+	TrustedProxies []netip.Prefix
+}
+
+func NewHandler(service *usecase.Service, opts Options) *Handler {
+	return &Handler{service: service, opts: opts}
 }
 
 func (h *Handler) Register(r chi.Router) {
-	r.Use(middleware.Logger)
+	r.Use(mw.Tracing, mw.RequestID, mw.AccessLog, mw.Metrics, middleware.Recoverer)
 
 	r.Get("/", h.rootHandler)
+	r.Get("/healthz", h.healthz)
+	r.Get("/readyz", h.readyz)
+
+	authLimit := mw.RateLimit(h.opts.AuthRateLimit, h.opts.AuthRateBurst, mw.ClientIP(h.opts.TrustedProxies))
+	apiLimit := mw.RateLimit(h.opts.APIRateLimit, h.opts.APIRateBurst, mw.UserKey)
 
-	r.Post("/api/auth", h.auth)
+	r.Group(func(r chi.Router) {
+		r.Use(authLimit)
+		if h.opts.LegacyAuth {
+			r.Post("/api/auth", h.auth)
+		}
+		r.Post("/api/register", h.register)
+		r.Post("/api/login", h.login)
+		r.Post("/api/token/refresh", h.refreshToken)
+	})
 
 	r.Group(func(r chi.Router) {
-		r.Use(mw.JWTAuthMiddleware)
+		r.Use(mw.JWTAuthMiddleware, apiLimit)
+		r.Post("/api/logout", h.logout)
+		r.Delete("/api/account", h.deleteAccount)
 		r.Get("/api/info", h.getInfo)
-		r.Post("/api/sendCoin", h.sendCoin)
-		r.Get("/api/buy/{item}", h.buyMerch)
+		r.Get("/api/items", h.listItems)
+		r.Get("/api/transactions", h.listTransactions)
+
+		r.Group(func(r chi.Router) {
+			r.Use(mw.Idempotency(h.service))
+			r.Post("/api/sendCoin", h.sendCoin)
+			r.Get("/api/buy/{item}", h.buyMerch)
+			r.Post("/api/orders", h.placeOrder)
+			r.Post("/api/purchases/{id}/refund", h.refundPurchase)
+		})
+	})
+
+	r.Route("/api/admin", func(r chi.Router) {
+		r.Use(mw.JWTAuthMiddleware, apiLimit)
+
+		r.Group(func(r chi.Router) {
+			r.Use(mw.RequirePermission(domain.PermManageCatalog))
+			r.Get("/items", h.adminListItems)
+			r.Post("/items", h.adminCreateItem)
+			r.Put("/items/{item}/price", h.adminUpdateItemPrice)
+			r.Put("/items/{item}/stock", h.adminUpdateItemStock)
+			r.Post("/items/{item}/retire", h.adminRetireItem)
+		})
+
+		r.With(mw.RequirePermission(domain.PermRefundAny), mw.Idempotency(h.service)).
+			Post("/purchases/{id}/refund", h.adminRefundPurchase)
+
+		r.Group(func(r chi.Router) {
+			r.Use(mw.RequirePermission(domain.PermManageLedger))
+			r.Get("/ledger/verify", h.adminVerifyLedger)
+			r.Post("/ledger/rebuild", h.adminRebuildBalances)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(mw.RequirePermission(domain.PermManageUsers))
+			r.Put("/users/{username}/role", h.adminSetUserRole)
+			r.Post("/users/{username}/freeze", h.adminFreezeUser)
+			r.Post("/users/{username}/unfreeze", h.adminUnfreezeUser)
+			r.Delete("/users/{username}", h.adminDeleteUser)
+		})
+
+		r.With(mw.RequirePermission(domain.PermGrantCoins), mw.Idempotency(h.service)).
+			Post("/grants", h.adminGrantCoins)
 	})
 }
 
@@ -45,12 +127,20 @@ func (h *Handler) rootHandler(w http.ResponseWriter, r *http.Request) {
   <h1>Добро пожаловать в Merch Shop</h1>
   <p>В этом сервисе вы можете:</p>
   <ul>
-    <li>Авторизоваться / зарегистрироваться: <strong>POST /api/auth</strong></li>
+    <li>Зарегистрироваться: <strong>POST /api/register</strong></li>
+    <li>Войти: <strong>POST /api/login</strong></li>
+    <li>Вход с автоматической регистрацией (устаревший, если включён): <strong>POST /api/auth</strong></li>
+    <li>Обновить токен доступа: <strong>POST /api/token/refresh</strong></li>
+    <li>Выйти и отозвать токены: <strong>POST /api/logout</strong> (JWT)</li>
+    <li>Удалить свой аккаунт: <strong>DELETE /api/account</strong> (JWT)</li>
     <li>Получить информацию о монетах, инвентаре, истории: <strong>GET /api/info</strong> 
       (требуется Bearer токен в заголовке <code>Authorization</code>)</li>
+    <li>История переводов с фильтрами и постраничной выдачей: <strong>GET /api/transactions</strong> (JWT)</li>
     <li>Отправить монеты другому пользователю: <strong>POST /api/sendCoin</strong> 
       (также JWT)</li>
+    <li>Посмотреть каталог мерча: <strong>GET /api/items</strong> (JWT)</li>
     <li>Купить мерч: <strong>GET /api/buy/{item}</strong> (JWT)</li>
+    <li>Оформить заказ из нескольких товаров: <strong>POST /api/orders</strong> (JWT)</li>
   </ul>
   <p>Для закрытых эндпоинтов передавайте заголовок:
     <code>Authorization: Bearer &lt;ваш-токен&gt;</code>
@@ -66,7 +156,9 @@ type authRequest struct {
 }
 
 type authResponse struct {
-	Token string `json:"token"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refreshToken"`
+	ExpiresIn    int    `json:"expiresIn"`
 }
 
 func (h *Handler) auth(w http.ResponseWriter, r *http.Request) {
@@ -77,40 +169,220 @@ func (h *Handler) auth(w http.ResponseWriter, r *http.Request) {
 	}
 	user, err := h.service.RegisterOrLogin(r.Context(), req.Username, req.Password)
 	if err != nil {
-		if err == usecase.ErrInvalidCredentials {
-			http.Error(w, `{"errors":"invalid credentials"}`, http.StatusUnauthorized)
+		writeAuthError(w, r, err)
+		return
+	}
+	h.issueTokens(w, r, http.StatusOK, user)
+}
+
+type registerRequest struct {
+	Username   string `json:"username"`
+	Password   string `json:"password"`
+	InviteCode string `json:"inviteCode"`
+}
+
+func (h *Handler) register(w http.ResponseWriter, r *http.Request) {
+	var req registerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"errors":"bad request"}`, http.StatusBadRequest)
+		return
+	}
+	user, err := h.service.Register(r.Context(), req.Username, req.Password, req.InviteCode)
+	if err != nil {
+		writeAuthError(w, r, err)
+		return
+	}
+	h.issueTokens(w, r, http.StatusCreated, user)
+}
+
+func (h *Handler) login(w http.ResponseWriter, r *http.Request) {
+	var req authRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"errors":"bad request"}`, http.StatusBadRequest)
+		return
+	}
+	user, err := h.service.Login(r.Context(), req.Username, req.Password)
+	if err != nil {
+		writeAuthError(w, r, err)
+		return
+	}
+	h.issueTokens(w, r, http.StatusOK, user)
+}
+
+func (h *Handler) issueTokens(w http.ResponseWriter, r *http.Request, status int, user *domain.User) {
+	refresh, err := h.service.IssueRefreshToken(r.Context(), user.ID)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	writeTokens(w, r, status, user, refresh)
+}
+
+func writeAuthError(w http.ResponseWriter, r *http.Request, err error) {
+	switch err {
+	case usecase.ErrInvalidCredentials:
+		http.Error(w, `{"errors":"invalid credentials"}`, http.StatusUnauthorized)
+	case usecase.ErrWeakPassword:
+		http.Error(w, `{"errors":"weak password"}`, http.StatusBadRequest)
+	case usecase.ErrInvalidUsername:
+		http.Error(w, `{"errors":"`+err.Error()+`"}`, http.StatusBadRequest)
+	case usecase.ErrUserExists:
+		http.Error(w, `{"errors":"`+err.Error()+`"}`, http.StatusConflict)
+	case usecase.ErrInvalidInviteCode, usecase.ErrRegistrationClosed, usecase.ErrAccountFrozen:
+		http.Error(w, `{"errors":"`+err.Error()+`"}`, http.StatusForbidden)
+	default:
+		writeInternalError(w, r, err)
+	}
+}
+
+type refreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+func (h *Handler) refreshToken(w http.ResponseWriter, r *http.Request) {
+	var req refreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"errors":"bad request"}`, http.StatusBadRequest)
+		return
+	}
+	user, refresh, err := h.service.RefreshSession(r.Context(), req.RefreshToken)
+	if err != nil {
+		if err == usecase.ErrInvalidRefreshToken {
+			http.Error(w, `{"errors":"invalid refresh token"}`, http.StatusUnauthorized)
 			return
 		}
-		if err == usecase.ErrWeakPassword {
-			http.Error(w, `{"errors":"weak password"}`, http.StatusBadRequest)
+		if err == usecase.ErrAccountFrozen {
+			http.Error(w, `{"errors":"account is frozen"}`, http.StatusForbidden)
 			return
 		}
-		http.Error(w, `{"errors":"`+err.Error()+`"}`, http.StatusInternalServerError)
+		writeInternalError(w, r, err)
 		return
 	}
+	writeTokens(w, r, http.StatusOK, user, refresh)
+}
 
-	token, err := mw.GenerateJWT(user.ID, user.Username)
-	if err != nil {
-		http.Error(w, `{"errors":"internal error"}`, http.StatusInternalServerError)
+func (h *Handler) logout(w http.ResponseWriter, r *http.Request) {
+	userID := mw.MustGetUserID(r.Context())
+	jti, expiresAt := mw.TokenFromContext(r.Context())
+
+	var req refreshRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, `{"errors":"bad request"}`, http.StatusBadRequest)
+			return
+		}
+	}
+	if err := h.service.Logout(r.Context(), userID, jti, expiresAt, req.RefreshToken); err != nil {
+		writeInternalError(w, r, err)
 		return
 	}
+	writeJSON(w, map[string]string{"status": "ok"})
+}
+
+type deleteAccountRequest struct {
+	Password string `json:"password"`
+}
 
-	writeJSON(w, authResponse{Token: token})
+func (h *Handler) deleteAccount(w http.ResponseWriter, r *http.Request) {
+	userID := mw.MustGetUserID(r.Context())
+	var req deleteAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"errors":"bad request"}`, http.StatusBadRequest)
+		return
+	}
+	if err := h.service.DeleteAccount(r.Context(), userID, req.Password); err != nil {
+		switch err {
+		case usecase.ErrInvalidCredentials:
+			http.Error(w, `{"errors":"invalid credentials"}`, http.StatusUnauthorized)
+		case usecase.ErrUserNotFound:
+			http.Error(w, `{"errors":"user not found"}`, http.StatusNotFound)
+		default:
+			writeInternalError(w, r, err)
+		}
+		return
+	}
+	writeJSON(w, map[string]string{"status": "deleted"})
+}
+
+func writeTokens(w http.ResponseWriter, r *http.Request, status int, user *domain.User, refresh string) {
+	token, err := mw.GenerateJWT(user.ID, user.Username, user.Role)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	writeJSONStatus(w, status, authResponse{
+		Token:        token,
+		RefreshToken: refresh,
+		ExpiresIn:    int(mw.AccessTokenTTL().Seconds()),
+	})
 }
 
 func (h *Handler) getInfo(w http.ResponseWriter, r *http.Request) {
 	userID := mw.MustGetUserID(r.Context())
 	info, err := h.service.GetInfo(r.Context(), userID)
 	if err != nil {
-		http.Error(w, `{"errors":"`+err.Error()+`"}`, http.StatusBadRequest)
+		writeInternalError(w, r, err)
 		return
 	}
 	writeJSON(w, info)
 }
 
+func (h *Handler) listItems(w http.ResponseWriter, r *http.Request) {
+	items, err := h.service.ListItems(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	writeJSON(w, items)
+}
+
+func (h *Handler) listTransactions(w http.ResponseWriter, r *http.Request) {
+	userID := mw.MustGetUserID(r.Context())
+
+	q := r.URL.Query()
+	query := usecase.TransactionQuery{
+		Direction:    q.Get("direction"),
+		Counterparty: q.Get("counterparty"),
+		Cursor:       q.Get("cursor"),
+	}
+	var err error
+	if v := q.Get("limit"); v != "" {
+		if query.Limit, err = strconv.Atoi(v); err != nil {
+			http.Error(w, `{"errors":"invalid limit"}`, http.StatusBadRequest)
+			return
+		}
+	}
+	if v := q.Get("from"); v != "" {
+		if query.From, err = time.Parse(time.RFC3339, v); err != nil {
+			http.Error(w, `{"errors":"from must be an RFC 3339 timestamp"}`, http.StatusBadRequest)
+			return
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		if query.To, err = time.Parse(time.RFC3339, v); err != nil {
+			http.Error(w, `{"errors":"to must be an RFC 3339 timestamp"}`, http.StatusBadRequest)
+			return
+		}
+	}
+
+	page, err := h.service.ListTransactions(r.Context(), userID, query)
+	if err != nil {
+		switch err {
+		case usecase.ErrInvalidDirection, usecase.ErrInvalidCursor, usecase.ErrInvalidLimit,
+			usecase.ErrInvalidDateRange, usecase.ErrUnknownCounterparty:
+			http.Error(w, `{"errors":"`+err.Error()+`"}`, http.StatusBadRequest)
+		default:
+			writeInternalError(w, r, err)
+		}
+		return
+	}
+	writeJSON(w, page)
+}
+
 type sendCoinRequest struct {
 	ToUser string `json:"toUser"`
 	Amount int    `json:"amount"`
+	Memo   string `json:"memo"`
 }
 
 func (h *Handler) sendCoin(w http.ResponseWriter, r *http.Request) {
@@ -122,12 +394,19 @@ func (h *Handler) sendCoin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.service.SendCoin(r.Context(), userID, req.ToUser, req.Amount); err != nil {
-		if err == usecase.ErrNotEnoughCoins {
+	if err := h.service.SendCoin(r.Context(), userID, req.ToUser, req.Amount, req.Memo); err != nil {
+		switch err {
+		case usecase.ErrNotEnoughCoins:
 			http.Error(w, `{"errors":"not enough coins"}`, http.StatusBadRequest)
-			return
+		case usecase.ErrAccountFrozen:
+			http.Error(w, `{"errors":"account is frozen"}`, http.StatusForbidden)
+		case usecase.ErrRecipientFrozen:
+			http.Error(w, `{"errors":"`+err.Error()+`"}`, http.StatusConflict)
+		case usecase.ErrInvalidAmount, usecase.ErrRecipientNotFound, usecase.ErrSelfTransfer, usecase.ErrMemoTooLong:
+			http.Error(w, `{"errors":"`+err.Error()+`"}`, http.StatusBadRequest)
+		default:
+			writeInternalError(w, r, err)
 		}
-		http.Error(w, `{"errors":"`+err.Error()+`"}`, http.StatusBadRequest)
 		return
 	}
 
@@ -142,18 +421,109 @@ func (h *Handler) buyMerch(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if err := h.service.BuyMerch(r.Context(), userID, itemName); err != nil {
-		if err == usecase.ErrNotEnoughCoins {
-			http.Error(w, `{"errors":"not enough coins"}`, http.StatusBadRequest)
+		writePurchaseError(w, r, err)
+		return
+	}
+
+	writeJSON(w, map[string]string{"status": "ok"})
+}
+
+type orderRequest struct {
+	Items []usecase.OrderItem `json:"items"`
+}
+
+func (h *Handler) placeOrder(w http.ResponseWriter, r *http.Request) {
+	userID := mw.MustGetUserID(r.Context())
+
+	var req orderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"errors":"bad request"}`, http.StatusBadRequest)
+		return
+	}
+	order, err := h.service.PlaceOrder(r.Context(), userID, req.Items)
+	if err != nil {
+		writePurchaseError(w, r, err)
+		return
+	}
+
+	writeJSON(w, order)
+}
+
+type refundRequest struct {
+	Quantity int `json:"quantity"`
+}
+
+func (h *Handler) refundPurchase(w http.ResponseWriter, r *http.Request) {
+	userID := mw.MustGetUserID(r.Context())
+	purchaseID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, `{"errors":"invalid purchase id"}`, http.StatusBadRequest)
+		return
+	}
+	var req refundRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, `{"errors":"bad request"}`, http.StatusBadRequest)
 			return
 		}
-		http.Error(w, `{"errors":"`+err.Error()+`"}`, http.StatusBadRequest)
+	}
+
+	refund, err := h.service.RefundPurchase(r.Context(), userID, purchaseID, req.Quantity)
+	if err != nil {
+		writeRefundError(w, r, err)
 		return
 	}
+	writeJSON(w, refund)
+}
 
-	writeJSON(w, map[string]string{"status": "ok"})
+func writeRefundError(w http.ResponseWriter, r *http.Request, err error) {
+	switch err {
+	case usecase.ErrPurchaseNotFound:
+		http.Error(w, `{"errors":"purchase not found"}`, http.StatusNotFound)
+	case usecase.ErrRefundWindowClosed, usecase.ErrNothingToRefund:
+		http.Error(w, `{"errors":"`+err.Error()+`"}`, http.StatusConflict)
+	case usecase.ErrAccountFrozen:
+		http.Error(w, `{"errors":"account is frozen"}`, http.StatusForbidden)
+	case usecase.ErrInvalidQuantity:
+		http.Error(w, `{"errors":"`+err.Error()+`"}`, http.StatusBadRequest)
+	default:
+		writeInternalError(w, r, err)
+	}
+}
+
+func writePurchaseError(w http.ResponseWriter, r *http.Request, err error) {
+	switch err {
+	case usecase.ErrNotEnoughCoins:
+		http.Error(w, `{"errors":"not enough coins"}`, http.StatusBadRequest)
+	case usecase.ErrUnknownItem:
+		http.Error(w, `{"errors":"unknown item"}`, http.StatusBadRequest)
+	case usecase.ErrOutOfStock:
+		http.Error(w, `{"errors":"out of stock"}`, http.StatusConflict)
+	case usecase.ErrItemRetired:
+		http.Error(w, `{"errors":"item is no longer available"}`, http.StatusGone)
+	case usecase.ErrAccountFrozen:
+		http.Error(w, `{"errors":"account is frozen"}`, http.StatusForbidden)
+	case usecase.ErrEmptyOrder, usecase.ErrTooManyLines, usecase.ErrInvalidQuantity:
+		http.Error(w, `{"errors":"`+err.Error()+`"}`, http.StatusBadRequest)
+	default:
+		writeInternalError(w, r, err)
+	}
+}
+
+// writeInternalError logs err server-side and answers with the request ID as
+// an opaque reference, so SQL and other internals never reach the client.
+func writeInternalError(w http.ResponseWriter, r *http.Request, err error) {
+	slog.ErrorContext(r.Context(), "request failed", slog.String("error", err.Error()))
+	http.Error(w, `{"errors":"internal error","reference":"`+logging.RequestID(r.Context())+`"}`,
+		http.StatusInternalServerError)
 }
 
 func writeJSON(w http.ResponseWriter, data interface{}) {
+	writeJSONStatus(w, http.StatusOK, data)
+}
+
+func writeJSONStatus(w http.ResponseWriter, status int, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
 	_ = json.NewEncoder(w).Encode(data)
 }
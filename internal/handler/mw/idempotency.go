@@ -0,0 +1,126 @@
+package mw
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"merchShop/internal/domain"
+)
+
+const (
+	IdempotencyKeyHeader = "Idempotency-Key"
+	maxIdempotencyKeyLen = 255
+)
+
+type IdempotencyStore interface {
+	ReserveIdempotencyKey(ctx context.Context, userID int, key, requestHash string) (*domain.IdempotencyRecord, bool, error)
+	CompleteIdempotencyKey(ctx context.Context, userID int, key string, status int, body []byte) error
+	ReleaseIdempotencyKey(ctx context.Context, userID int, key string) error
+}
+
+// Idempotency replays the stored response when a request is retried with the
+// same Idempotency-Key, so a money-moving request is executed at most once.
+// Requests without the header are passed through unchanged. It must be
+// mounted after JWTAuthMiddleware because keys are scoped per user.
+func Idempotency(store IdempotencyStore) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(IdempotencyKeyHeader)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > maxIdempotencyKeyLen {
+				http.Error(w, `{"errors":"idempotency key is too long"}`, http.StatusBadRequest)
+				return
+			}
+			userID := MustGetUserID(r.Context())
+
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, `{"errors":"bad request"}`, http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			sum := sha256.New()
+			sum.Write([]byte(r.Method + " " + r.URL.RequestURI() + "\n"))
+			sum.Write(body)
+			hash := hex.EncodeToString(sum.Sum(nil))
+
+			rec, reserved, err := store.ReserveIdempotencyKey(r.Context(), userID, key, hash)
+			if err != nil {
+				http.Error(w, `{"errors":"internal error"}`, http.StatusInternalServerError)
+				return
+			}
+			if !reserved {
+				switch {
+				case rec.RequestHash != hash:
+					http.Error(w, `{"errors":"idempotency key was already used for a different request"}`,
+						http.StatusUnprocessableEntity)
+				case rec.StatusCode == 0:
+					http.Error(w, `{"errors":"a request with this idempotency key is still in progress"}`,
+						http.StatusConflict)
+				default:
+					w.Header().Set("Content-Type", "application/json")
+					w.Header().Set("Idempotent-Replayed", "true")
+					w.WriteHeader(rec.StatusCode)
+					_, _ = w.Write(rec.Body)
+				}
+				return
+			}
+
+			rw := &recordingWriter{ResponseWriter: w, status: http.StatusOK}
+			release := func() {
+				_ = store.ReleaseIdempotencyKey(context.WithoutCancel(r.Context()), userID, key)
+			}
+			defer func() {
+				// A panic before anything was written means the request
+				// failed, so the client may retry with the same key. Once a
+				// response exists the money may have moved; the key stays
+				// in progress.
+				if p := recover(); p != nil {
+					if !rw.wrote {
+						release()
+					}
+					panic(p)
+				}
+			}()
+			next.ServeHTTP(rw, r)
+
+			// Server errors are stored like any other response: a failed
+			// commit may still have moved the money, so a retry with the same
+			// key must not run the request again.
+			if err := store.CompleteIdempotencyKey(context.WithoutCancel(r.Context()), userID, key, rw.status, rw.body.Bytes()); err != nil {
+				// The request may have taken effect, so the key must not be released:
+				// retries get 409 until it expires instead of running again.
+				slog.ErrorContext(r.Context(), "cannot store idempotent response",
+					slog.String("error", err.Error()), slog.Int("status", rw.status))
+			}
+		})
+	}
+}
+
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+	wrote  bool
+}
+
+func (w *recordingWriter) WriteHeader(status int) {
+	w.status = status
+	w.wrote = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	w.wrote = true
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
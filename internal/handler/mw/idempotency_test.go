@@ -0,0 +1,166 @@
+package mw
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"merchShop/internal/domain"
+)
+
+type memStore struct {
+	records     map[string]*domain.IdempotencyRecord
+	completeErr error
+}
+
+func (m *memStore) ReserveIdempotencyKey(ctx context.Context, userID int, key, requestHash string) (*domain.IdempotencyRecord, bool, error) {
+	if rec, ok := m.records[key]; ok {
+		return rec, false, nil
+	}
+	m.records[key] = &domain.IdempotencyRecord{RequestHash: requestHash}
+	return nil, true, nil
+}
+
+func (m *memStore) CompleteIdempotencyKey(ctx context.Context, userID int, key string, status int, body []byte) error {
+	if m.completeErr != nil {
+		return m.completeErr
+	}
+	m.records[key].StatusCode = status
+	m.records[key].Body = body
+	return nil
+}
+
+func (m *memStore) ReleaseIdempotencyKey(ctx context.Context, userID int, key string) error {
+	delete(m.records, key)
+	return nil
+}
+
+func TestIdempotency(t *testing.T) {
+	store := &memStore{records: make(map[string]*domain.IdempotencyRecord)}
+	calls := 0
+	status := http.StatusOK
+	var h http.Handler
+	var inFlight *httptest.ResponseRecorder
+
+	do := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/sendCoin", strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), userCtxKey, 1))
+		if key != "" {
+			req.Header.Set(IdempotencyKeyHeader, key)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	h = Idempotency(store)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.Header.Get(IdempotencyKeyHeader) == "k2" {
+			// A retry that arrives while the original is still running.
+			inFlight = do("k2", `{}`)
+		}
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(`{"status":"ok"}`))
+	}))
+
+	first := do("k1", `{"amount":1}`)
+	assert.Equal(t, http.StatusOK, first.Code)
+	replay := do("k1", `{"amount":1}`)
+	assert.Equal(t, http.StatusOK, replay.Code)
+	assert.Equal(t, first.Body.String(), replay.Body.String())
+	assert.Equal(t, "true", replay.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, 1, calls, "replay must not execute the handler again")
+
+	mismatch := do("k1", `{"amount":2}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, mismatch.Code)
+	assert.Equal(t, 1, calls)
+
+	do("k2", `{}`)
+	assert.Equal(t, http.StatusConflict, inFlight.Code)
+	assert.Equal(t, 2, calls)
+
+	status = http.StatusInternalServerError
+	assert.Equal(t, http.StatusInternalServerError, do("k3", `{}`).Code)
+	replay = do("k3", `{}`)
+	assert.Equal(t, http.StatusInternalServerError, replay.Code, "server errors are replayed, not retried")
+	assert.Equal(t, "true", replay.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, 3, calls)
+
+	do("", `{}`)
+	do("", `{}`)
+	assert.Equal(t, 5, calls, "requests without a key are not deduplicated")
+}
+
+func TestIdempotency_ServerErrorAfterCommit(t *testing.T) {
+	store := &memStore{records: make(map[string]*domain.IdempotencyRecord)}
+	balance := 1000
+	h := Idempotency(store)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The transfer was committed, but the handler still answers 500,
+		// e.g. because the commit reported an error after succeeding.
+		balance -= 100
+		http.Error(w, `{"errors":"internal error"}`, http.StatusInternalServerError)
+	}))
+	do := func() int {
+		req := httptest.NewRequest(http.MethodPost, "/api/sendCoin", strings.NewReader(`{"amount":100}`))
+		req = req.WithContext(context.WithValue(req.Context(), userCtxKey, 1))
+		req.Header.Set(IdempotencyKeyHeader, "k1")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusInternalServerError, do())
+	assert.Equal(t, http.StatusInternalServerError, do())
+	assert.Equal(t, 900, balance, "a retry after a 500 must not move the money again")
+}
+
+func TestIdempotency_KeyKeptAfterResponse(t *testing.T) {
+	store := &memStore{records: make(map[string]*domain.IdempotencyRecord)}
+	calls := 0
+	panicAfterWrite := false
+	h := Idempotency(store)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.Header.Get(IdempotencyKeyHeader) == "panic" {
+			if panicAfterWrite {
+				w.WriteHeader(http.StatusOK)
+			}
+			panic("boom")
+		}
+		_, _ = w.Write([]byte(`{"status":"ok"}`))
+	}))
+	do := func(key string) (rec *httptest.ResponseRecorder, panicked bool) {
+		req := httptest.NewRequest(http.MethodPost, "/api/buy/cup", strings.NewReader(`{}`))
+		req = req.WithContext(context.WithValue(req.Context(), userCtxKey, 1))
+		req.Header.Set(IdempotencyKeyHeader, key)
+		rec = httptest.NewRecorder()
+		defer func() { panicked = recover() != nil }()
+		h.ServeHTTP(rec, req)
+		return rec, false
+	}
+
+	// The purchase went through but its response could not be stored.
+	store.completeErr = errors.New("db is down")
+	first, _ := do("k1")
+	assert.Equal(t, http.StatusOK, first.Code)
+	store.completeErr = nil
+
+	retry, _ := do("k1")
+	assert.Equal(t, http.StatusConflict, retry.Code, "the retry must not run the purchase again")
+	assert.Equal(t, 1, calls)
+
+	_, panicked := do("panic")
+	assert.True(t, panicked)
+	_, kept := store.records["panic"]
+	assert.False(t, kept, "a panic before any response releases the key")
+
+	panicAfterWrite = true
+	do("panic")
+	_, kept = store.records["panic"]
+	assert.True(t, kept, "a panic after the response keeps the key")
+	assert.Equal(t, 3, calls)
+}
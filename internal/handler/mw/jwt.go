@@ -2,27 +2,51 @@ package mw
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
+
+	"merchShop/internal/domain"
+	"merchShop/internal/logging"
 )
 
 const (
 	hoursInADay = 24
 	splitSize   = 2
+	jtiBytes    = 16
+)
+
+var (
+	secretKey      []byte
+	accessTokenTTL = hoursInADay * time.Hour
+	tokenValidator TokenValidator
 )
 
-var secretKey []byte
+// TokenValidator is consulted after the signature check on every request so
+// that tokens can be revoked before they expire. The role it returns replaces
+// the one in the token, so role changes apply immediately.
+type TokenValidator interface {
+	ValidateAccessToken(ctx context.Context, userID int, jti string) (domain.Role, error)
+}
 
 type userCtxKeyType int
 
-const userCtxKey userCtxKeyType = iota
+const (
+	userCtxKey userCtxKeyType = iota
+	roleCtxKey
+	tokenIDCtxKey
+	tokenExpiryCtxKey
+)
 
 type customClaims struct {
 	UserID   int    `json:"user_id"`
 	Username string `json:"username"`
+	Role     string `json:"role,omitempty"`
 	jwt.RegisteredClaims
 }
 
@@ -30,12 +54,35 @@ func SetSecretKey(key []byte) {
 	secretKey = key
 }
 
-func GenerateJWT(userID int, username string) (string, error) {
+// SetAccessTokenTTL sets the lifetime of tokens issued by GenerateJWT.
+func SetAccessTokenTTL(ttl time.Duration) {
+	accessTokenTTL = ttl
+}
+
+// SetTokenValidator enables the revocation check in JWTAuthMiddleware.
+func SetTokenValidator(v TokenValidator) {
+	tokenValidator = v
+}
+
+// AccessTokenTTL reports the lifetime of newly issued access tokens.
+func AccessTokenTTL() time.Duration {
+	return accessTokenTTL
+}
+
+func GenerateJWT(userID int, username string, role domain.Role) (string, error) {
+	jti := make([]byte, jtiBytes)
+	if _, err := rand.Read(jti); err != nil {
+		return "", err
+	}
+	now := time.Now()
 	claims := customClaims{
 		UserID:   userID,
 		Username: username,
+		Role:     string(role),
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(hoursInADay * time.Hour)), // вместо 24
+			ID:        hex.EncodeToString(jti),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(accessTokenTTL)),
 		},
 	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
@@ -74,11 +121,43 @@ func JWTAuthMiddleware(next http.Handler) http.Handler {
 			http.Error(w, `{"errors":"unauthorized"}`, http.StatusUnauthorized)
 			return
 		}
-		ctx := context.WithValue(r.Context(), userCtxKey, claims.UserID)
+		role := domain.Role(claims.Role)
+		if tokenValidator != nil {
+			role, err = tokenValidator.ValidateAccessToken(r.Context(), claims.UserID, claims.ID)
+			if err != nil {
+				if errors.Is(err, domain.ErrAccountFrozen) {
+					http.Error(w, `{"errors":"account is frozen"}`, http.StatusForbidden)
+					return
+				}
+				http.Error(w, `{"errors":"unauthorized"}`, http.StatusUnauthorized)
+				return
+			}
+		}
+		ctx := logging.WithUserID(r.Context(), claims.UserID)
+		ctx = context.WithValue(ctx, userCtxKey, claims.UserID)
+		ctx = context.WithValue(ctx, roleCtxKey, role)
+		ctx = context.WithValue(ctx, tokenIDCtxKey, claims.ID)
+		if claims.ExpiresAt != nil {
+			ctx = context.WithValue(ctx, tokenExpiryCtxKey, claims.ExpiresAt.Time)
+		}
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// RequirePermission rejects requests whose user's role does not grant p. It
+// must be mounted after JWTAuthMiddleware.
+func RequirePermission(p domain.Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !GetRole(r.Context()).Can(p) {
+				http.Error(w, `{"errors":"forbidden"}`, http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 func MustGetUserID(ctx context.Context) int {
 	val := ctx.Value(userCtxKey)
 	if val == nil {
@@ -86,3 +165,16 @@ func MustGetUserID(ctx context.Context) int {
 	}
 	return val.(int)
 }
+
+func GetRole(ctx context.Context) domain.Role {
+	val, _ := ctx.Value(roleCtxKey).(domain.Role)
+	return val
+}
+
+// TokenFromContext returns the jti and expiry of the access token the request
+// was authenticated with.
+func TokenFromContext(ctx context.Context) (string, time.Time) {
+	jti, _ := ctx.Value(tokenIDCtxKey).(string)
+	exp, _ := ctx.Value(tokenExpiryCtxKey).(time.Time)
+	return jti, exp
+}
@@ -0,0 +1,44 @@
+package mw
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"merchShop/internal/domain"
+)
+
+type roleStore map[int]domain.Role
+
+func (s roleStore) ValidateAccessToken(ctx context.Context, userID int, jti string) (domain.Role, error) {
+	return s[userID], nil
+}
+
+func TestRequirePermission_UsesCurrentRole(t *testing.T) {
+	prevKey, prevValidator := secretKey, tokenValidator
+	defer func() { secretKey, tokenValidator = prevKey, prevValidator }()
+
+	roles := roleStore{1: domain.RoleAdmin}
+	SetSecretKey([]byte("test-secret"))
+	SetTokenValidator(roles)
+
+	token, err := GenerateJWT(1, "Admin", domain.RoleAdmin)
+	assert.NoError(t, err)
+
+	h := JWTAuthMiddleware(RequirePermission(domain.PermManageUsers)(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	do := func() int {
+		req := httptest.NewRequest(http.MethodPut, "/api/admin/users/Ali/role", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	assert.Equal(t, http.StatusOK, do())
+
+	roles[1] = domain.RoleEmployee
+	assert.Equal(t, http.StatusForbidden, do(), "a demoted admin's old token must not open admin routes")
+}
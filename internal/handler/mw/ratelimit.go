@@ -0,0 +1,127 @@
+package mw
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// limiterIdleTTL is how long an unused per-client limiter is kept.
+const limiterIdleTTL = 10 * time.Minute
+
+// RateLimit allows each client rps requests per second with bursts of up to
+// burst requests and answers 429 above that. Clients are told apart by key.
+// A zero rps disables the limit.
+func RateLimit(rps float64, burst int, key func(*http.Request) string) func(http.Handler) http.Handler {
+	if rps <= 0 {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	limiters := &limiterSet{limit: rate.Limit(rps), burst: burst, clients: make(map[string]*client)}
+	retryAfter := strconv.Itoa(int(1/rps) + 1)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !limiters.allow(key(r)) {
+				w.Header().Set("Retry-After", retryAfter)
+				http.Error(w, `{"errors":"too many requests"}`, http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ClientIP returns a key function that rate-limits by client IP. The
+// connection's remote address is used unless it belongs to one of the
+// trusted proxies; only then are X-Forwarded-For and X-Real-IP believed.
+// X-Forwarded-For is walked from the right, skipping trusted hops, so a
+// client cannot pick its own key by prepending addresses.
+func ClientIP(trusted []netip.Prefix) func(*http.Request) string {
+	isTrusted := func(addr netip.Addr) bool {
+		for _, p := range trusted {
+			if p.Contains(addr) {
+				return true
+			}
+		}
+		return false
+	}
+	return func(r *http.Request) string {
+		remote := remoteIP(r)
+		addr, err := netip.ParseAddr(remote)
+		if err != nil || !isTrusted(addr.Unmap()) {
+			return remote
+		}
+		if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+			hops := strings.Split(strings.Join(xff, ","), ",")
+			for i := len(hops) - 1; i >= 0; i-- {
+				hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+				if err != nil {
+					break
+				}
+				if !isTrusted(hop.Unmap()) || i == 0 {
+					return hop.Unmap().String()
+				}
+			}
+			return remote
+		}
+		if ip, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+			return ip.Unmap().String()
+		}
+		return remote
+	}
+}
+
+// remoteIP is the host part of the connection's remote address.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// UserKey keys rate limits by the authenticated user. It must be mounted
+// after JWTAuthMiddleware.
+func UserKey(r *http.Request) string {
+	return strconv.Itoa(MustGetUserID(r.Context()))
+}
+
+type client struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+type limiterSet struct {
+	mu        sync.Mutex
+	limit     rate.Limit
+	burst     int
+	clients   map[string]*client
+	lastSweep time.Time
+}
+
+func (s *limiterSet) allow(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.lastSweep) > limiterIdleTTL {
+		for k, c := range s.clients {
+			if now.Sub(c.lastSeen) > limiterIdleTTL {
+				delete(s.clients, k)
+			}
+		}
+		s.lastSweep = now
+	}
+	c, ok := s.clients[key]
+	if !ok {
+		c = &client{limiter: rate.NewLimiter(s.limit, s.burst)}
+		s.clients[key] = c
+	}
+	c.lastSeen = now
+	return c.limiter.AllowN(now, 1)
+}
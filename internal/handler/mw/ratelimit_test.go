@@ -0,0 +1,46 @@
+package mw
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimit(t *testing.T) {
+	h := RateLimit(1, 2, ClientIP(nil))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	do := func(addr string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/login", nil)
+		req.RemoteAddr = addr
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	assert.Equal(t, http.StatusOK, do("10.0.0.1:1000"))
+	assert.Equal(t, http.StatusOK, do("10.0.0.1:1001"))
+	assert.Equal(t, http.StatusTooManyRequests, do("10.0.0.1:1002"), "burst is per IP, not per port")
+	assert.Equal(t, http.StatusOK, do("10.0.0.2:1000"))
+}
+
+func TestClientIP(t *testing.T) {
+	key := ClientIP([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")})
+
+	do := func(addr string, header ...string) string {
+		req := httptest.NewRequest(http.MethodPost, "/api/login", nil)
+		req.RemoteAddr = addr
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Add(header[i], header[i+1])
+		}
+		return key(req)
+	}
+	assert.Equal(t, "203.0.113.7", do("203.0.113.7:1000", "X-Forwarded-For", "1.2.3.4"), "untrusted peer cannot spoof")
+	assert.Equal(t, "203.0.113.7", do("203.0.113.7:1000", "X-Real-IP", "1.2.3.4"), "untrusted peer cannot spoof")
+	assert.Equal(t, "198.51.100.1", do("10.0.0.1:1000", "X-Forwarded-For", "198.51.100.1"))
+	assert.Equal(t, "198.51.100.1", do("10.0.0.1:1000", "X-Forwarded-For", "1.2.3.4, 198.51.100.1, 10.0.0.2"),
+		"rightmost untrusted hop wins over client-supplied ones")
+	assert.Equal(t, "198.51.100.2", do("10.0.0.1:1000", "X-Real-IP", "198.51.100.2"))
+	assert.Equal(t, "10.0.0.1", do("10.0.0.1:1000"), "trusted proxy without headers")
+}
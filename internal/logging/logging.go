@@ -0,0 +1,96 @@
+// Package logging configures the process-wide slog logger and carries
+// request-scoped fields (request ID, user ID) through context.Context, so
+// every layer logs them without passing them around explicitly.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+type ctxKey int
+
+const fieldsCtxKey ctxKey = iota
+
+// fields is shared by pointer so that a user ID learned deep in the
+// middleware chain is visible to the access log wrapped around it.
+type fields struct {
+	requestID string
+	userID    int
+}
+
+// WithRequestID starts the request-scoped fields for ctx.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, fieldsCtxKey, &fields{requestID: id})
+}
+
+// WithUserID records the authenticated user for the rest of the request.
+func WithUserID(ctx context.Context, userID int) context.Context {
+	if f, ok := ctx.Value(fieldsCtxKey).(*fields); ok {
+		f.userID = userID
+		return ctx
+	}
+	return context.WithValue(ctx, fieldsCtxKey, &fields{userID: userID})
+}
+
+func RequestID(ctx context.Context) string {
+	if f, ok := ctx.Value(fieldsCtxKey).(*fields); ok {
+		return f.requestID
+	}
+	return ""
+}
+
+func UserID(ctx context.Context) int {
+	if f, ok := ctx.Value(fieldsCtxKey).(*fields); ok {
+		return f.userID
+	}
+	return 0
+}
+
+// New returns a JSON logger that adds request_id, user_id and the current
+// trace_id from the context to every record logged with one of the *Context
+// methods.
+func New(w io.Writer, level slog.Level) *slog.Logger {
+	return slog.New(contextHandler{slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level})})
+}
+
+// ParseLevel accepts debug, info, warn and error.
+func ParseLevel(s string) (slog.Level, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(strings.ToUpper(s))); err != nil {
+		return 0, fmt.Errorf("unknown log level %q", s)
+	}
+	return l, nil
+}
+
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if f, ok := ctx.Value(fieldsCtxKey).(*fields); ok {
+		if f.requestID != "" {
+			r.AddAttrs(slog.String("request_id", f.requestID))
+		}
+		if f.userID != 0 {
+			r.AddAttrs(slog.Int("user_id", f.userID))
+		}
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(slog.String("trace_id", sc.TraceID().String()))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
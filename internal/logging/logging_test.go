@@ -0,0 +1,35 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContextFields(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, slog.LevelInfo)
+
+	ctx := WithRequestID(context.Background(), "req-1")
+	// The user is authenticated after the request ID was attached, but the
+	// fields are shared, so the outer context sees it too.
+	_ = WithUserID(ctx, 42)
+	logger.InfoContext(ctx, "hello")
+	logger.DebugContext(ctx, "dropped")
+
+	var rec map[string]interface{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &rec))
+	assert.Equal(t, "hello", rec["msg"])
+	assert.Equal(t, "req-1", rec["request_id"])
+	assert.Equal(t, float64(42), rec["user_id"])
+
+	_, err := ParseLevel("verbose")
+	assert.Error(t, err)
+	l, err := ParseLevel("debug")
+	assert.NoError(t, err)
+	assert.Equal(t, slog.LevelDebug, l)
+}
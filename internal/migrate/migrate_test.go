@@ -0,0 +1,144 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+
+	"merchShop/internal/pgtest"
+	"merchShop/migrations"
+)
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0002_add_memo.up.sql":   {Data: []byte("ALTER TABLE t ADD memo TEXT;")},
+		"0002_add_memo.down.sql": {Data: []byte("ALTER TABLE t DROP memo;")},
+		"0001_init.up.sql":       {Data: []byte("CREATE TABLE t (id INT);")},
+		"0001_init.down.sql":     {Data: []byte("DROP TABLE t;")},
+		"README.md":              {Data: []byte("ignored")},
+	}
+	migs, err := Load(fsys)
+	assert.NoError(t, err)
+	if assert.Len(t, migs, 2) {
+		assert.Equal(t, 1, migs[0].Version)
+		assert.Equal(t, "init", migs[0].Name)
+		assert.Equal(t, "DROP TABLE t;", migs[0].Down)
+		assert.Equal(t, "add_memo", migs[1].Name)
+	}
+
+	delete(fsys, "0002_add_memo.down.sql")
+	_, err = Load(fsys)
+	assert.Error(t, err, "a migration without a down file must be rejected")
+
+	fsys["0002_memo.down.sql"] = &fstest.MapFile{Data: []byte("ALTER TABLE t DROP memo;")}
+	_, err = Load(fsys)
+	assert.Error(t, err, "up and down files must share the name")
+
+	embedded, err := Load(migrations.FS)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, embedded)
+}
+
+// TestUpgradeFromBaseline starts from a database created by the original
+// init.sql, before migrations were tracked, and upgrades it to the latest
+// schema.
+func TestUpgradeFromBaseline(t *testing.T) {
+	db := pgtest.Open(t)
+	ctx := context.Background()
+
+	baseline, err := fs.ReadFile(migrations.FS, "0001_init.up.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(string(baseline)); err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`INSERT INTO users (username, password_hash) VALUES ('alice', 'x'), ('bob', 'x');
+	                  UPDATE users SET coins = 700 WHERE username = 'alice';
+	                  UPDATE users SET coins = 1300 WHERE username = 'bob';
+	                  INSERT INTO coin_transactions (from_user_id, to_user_id, amount)
+	                  SELECT a.id, b.id, 300 FROM users a, users b WHERE a.username = 'alice' AND b.username = 'bob';`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := New(db, migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	all, _ := Load(migrations.FS)
+	applied, err := m.Up(ctx)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Len(t, applied, len(all), "a baseline database has every migration pending")
+	pending, err := m.Pending(ctx)
+	assert.NoError(t, err)
+	assert.Zero(t, pending)
+
+	var role, status string
+	var coins int
+	err = db.QueryRow("SELECT role, status, coins FROM users WHERE username = 'alice'").Scan(&role, &status, &coins)
+	assert.NoError(t, err)
+	assert.Equal(t, "employee", role)
+	assert.Equal(t, "active", status)
+	assert.Equal(t, 700, coins, "existing balances are kept")
+
+	var memo string
+	err = db.QueryRow("SELECT memo FROM coin_transactions WHERE ledger_entry_id IS NULL").Scan(&memo)
+	assert.NoError(t, err, "old transfers get the new columns")
+	assert.Empty(t, memo)
+
+	// Old wallets are opened in the ledger with their current balance.
+	rows, err := db.Query(`SELECT u.coins, a.code = 'user:' || u.id, COALESCE(SUM(p.amount), 0)
+	                       FROM users u
+	                       LEFT JOIN ledger_accounts a ON a.user_id = u.id
+	                       LEFT JOIN ledger_postings p ON p.account_id = a.id
+	                       GROUP BY u.id, a.code ORDER BY u.id`)
+	if !assert.NoError(t, err) {
+		return
+	}
+	wallets := 0
+	for rows.Next() {
+		var cached, ledger int
+		var hasAccount sql.NullBool
+		assert.NoError(t, rows.Scan(&cached, &hasAccount, &ledger))
+		assert.True(t, hasAccount.Bool, "every user gets a ledger account")
+		assert.Equal(t, cached, ledger, "opening balance matches users.coins")
+		wallets++
+	}
+	rows.Close()
+	assert.Equal(t, 2, wallets)
+	var issued int
+	err = db.QueryRow(`SELECT -SUM(p.amount) FROM ledger_postings p
+	                   JOIN ledger_accounts a ON a.id = p.account_id
+	                   JOIN ledger_entries e ON e.id = p.entry_id
+	                   WHERE a.code = 'system:issuance' AND e.kind = 'signup'`).Scan(&issued)
+	assert.NoError(t, err)
+	assert.Equal(t, 2000, issued)
+	var inLots int
+	err = db.QueryRow("SELECT SUM(remaining) FROM coin_lots").Scan(&inLots)
+	assert.NoError(t, err)
+	assert.Equal(t, 2000, inLots, "coins held before lots existed can expire too")
+
+	// Every down script must undo its up script.
+	for i := 0; i < len(all); i++ {
+		mig, err := m.Down(ctx)
+		if !assert.NoError(t, err) || !assert.NotNil(t, mig) {
+			return
+		}
+		assert.Equal(t, all[len(all)-1-i].Version, mig.Version)
+	}
+	var left int
+	err = db.QueryRow("SELECT COUNT(*) FROM pg_tables WHERE schemaname = current_schema() AND tablename <> 'schema_migrations'").Scan(&left)
+	assert.NoError(t, err)
+	assert.Zero(t, left, "rolling everything back leaves no tables")
+
+	applied, err = m.Up(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, applied, len(all))
+}
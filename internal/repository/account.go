@@ -0,0 +1,56 @@
+package repository
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/pkg/errors"
+
+	"merchShop/internal/domain"
+)
+
+// DeleteUserTx anonymises the account in a single transaction: the remaining
+// balance is forfeited through the ledger, the username and password hash are
+// replaced, inventory, sessions and stored idempotent responses are removed.
+// Transfers, purchases and ledger postings keep referencing the row, so
+// balances and other users' histories are unaffected.
+func (r *PostgresRepo) DeleteUserTx(ctx context.Context, userID, actorID int) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	var coins int
+	if err := tx.QueryRowContext(ctx, "SELECT coins FROM users WHERE id = $1 FOR UPDATE", userID).Scan(&coins); err != nil {
+		_ = tx.Rollback()
+		return errors.Wrap(err, "repo: DeleteUserTx")
+	}
+	if coins > 0 {
+		_, err = postEntry(ctx, tx, domain.LedgerEntry{
+			Kind:     domain.EntryForfeit,
+			ActorID:  actorID,
+			Postings: domain.Transfer(domain.UserAccount(userID), domain.AccountForfeited, coins),
+		})
+		if err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+
+	statements := []struct {
+		query string
+		args  []interface{}
+	}{
+		{`UPDATE users SET username = $2, password_hash = '', role = $3, status = $4 WHERE id = $1`,
+			[]interface{}{userID, domain.DeletedUsernamePrefix + strconv.Itoa(userID), domain.RoleEmployee, domain.StatusDeleted}},
+		{`DELETE FROM user_inventory WHERE user_id = $1`, []interface{}{userID}},
+		{`DELETE FROM idempotency_keys WHERE user_id = $1`, []interface{}{userID}},
+		{`UPDATE refresh_tokens SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`, []interface{}{userID}},
+	}
+	for _, st := range statements {
+		if _, err := tx.ExecContext(ctx, st.query, st.args...); err != nil {
+			_ = tx.Rollback()
+			return errors.Wrap(err, "repo: DeleteUserTx")
+		}
+	}
+	return tx.Commit()
+}
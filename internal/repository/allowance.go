@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"merchShop/internal/domain"
+)
+
+// PayAllowance credits amount coins to every active user not yet paid for
+// period and returns how many users were paid. Each user is paid in their own
+// transaction guarded by allowance_payouts, so a crash midway leaves the
+// remaining users for the next run and never pays anyone twice. Frozen users
+// are skipped and are not paid retroactively for the period.
+func (r *PostgresRepo) PayAllowance(ctx context.Context, period string, amount int, memo string) (int, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT u.id FROM users u
+		 WHERE u.status = 'active'
+		   AND NOT EXISTS (SELECT 1 FROM allowance_payouts p WHERE p.period = $1 AND p.user_id = u.id)
+		 ORDER BY u.id;`, period)
+	if err != nil {
+		return 0, errors.Wrap(err, "repo: PayAllowance")
+	}
+	var userIDs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		userIDs = append(userIDs, id)
+	}
+	rows.Close()
+
+	paid := 0
+	for _, userID := range userIDs {
+		ok, err := r.payAllowanceTo(ctx, userID, period, amount, memo)
+		if err != nil {
+			return paid, err
+		}
+		if ok {
+			paid++
+		}
+	}
+	return paid, nil
+}
+
+func (r *PostgresRepo) payAllowanceTo(ctx context.Context, userID int, period string, amount int, memo string) (bool, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	entryID, err := postEntry(ctx, tx, domain.LedgerEntry{
+		Kind:     domain.EntryAllowance,
+		Postings: domain.Transfer(domain.AccountIssuance, domain.UserAccount(userID), amount),
+	})
+	if err != nil {
+		_ = tx.Rollback()
+		return false, err
+	}
+	res, err := tx.ExecContext(ctx,
+		`INSERT INTO allowance_payouts (period, user_id, amount, ledger_entry_id) VALUES ($1, $2, $3, $4)
+		 ON CONFLICT (period, user_id) DO NOTHING`, period, userID, amount, entryID)
+	if err != nil {
+		_ = tx.Rollback()
+		return false, errors.Wrap(err, "repo: PayAllowance")
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		// Another replica paid this user in the meantime.
+		_ = tx.Rollback()
+		return false, nil
+	}
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO coin_transactions (from_user_id, to_user_id, amount, memo, ledger_entry_id) VALUES (NULL, $1, $2, $3, $4)",
+		userID, amount, memo, entryID)
+	if err != nil {
+		_ = tx.Rollback()
+		return false, errors.Wrap(err, "repo: PayAllowance")
+	}
+	return true, tx.Commit()
+}
@@ -0,0 +1,33 @@
+package repository
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"merchShop/internal/domain"
+)
+
+func TestBuyMerchTxUnavailableItem(t *testing.T) {
+	repo := newTestRepo(t)
+	ctx := context.Background()
+	alice := createTestUser(t, repo, "alice", 1000)
+	if _, err := repo.CreateMerchItem(ctx, "mug", 10, 1, ""); err != nil {
+		t.Fatal(err)
+	}
+	buy := func(item string, qty int) error {
+		_, err := repo.BuyMerchTx(ctx, alice, []domain.OrderLine{{ItemName: item, Quantity: qty}})
+		return err
+	}
+
+	assert.Equal(t, domain.ErrOutOfStock, buy("mug", 2))
+	assert.Equal(t, domain.ErrUnknownItem, buy("kettle", 1))
+
+	// Retired after the service priced the order.
+	if err := repo.SetMerchItemActive(ctx, "mug", false); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, domain.ErrItemRetired, buy("mug", 1))
+	assert.Equal(t, 1000, coinsOf(t, repo, alice))
+}
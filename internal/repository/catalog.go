@@ -0,0 +1,91 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	"merchShop/internal/domain"
+)
+
+func (r *PostgresRepo) GetMerchItem(ctx context.Context, name string) (*domain.MerchItem, error) {
+	query := `SELECT id, name, price, stock, description, active, created_at FROM merch_items WHERE name = $1;`
+	row := r.db.QueryRowContext(ctx, query, name)
+	it := &domain.MerchItem{}
+	if err := row.Scan(&it.ID, &it.Name, &it.Price, &it.Stock, &it.Description, &it.Active, &it.CreatedAt); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "repo: GetMerchItem")
+	}
+	return it, nil
+}
+
+func (r *PostgresRepo) ListMerchItems(ctx context.Context, activeOnly bool) ([]domain.MerchItem, error) {
+	query := `SELECT id, name, price, stock, description, active, created_at
+	          FROM merch_items
+	          WHERE active OR NOT $1
+	          ORDER BY name;`
+	rows, err := r.db.QueryContext(ctx, query, activeOnly)
+	if err != nil {
+		return nil, errors.Wrap(err, "repo: ListMerchItems")
+	}
+	defer rows.Close()
+
+	var res []domain.MerchItem
+	for rows.Next() {
+		var it domain.MerchItem
+		if err := rows.Scan(&it.ID, &it.Name, &it.Price, &it.Stock, &it.Description, &it.Active, &it.CreatedAt); err != nil {
+			return nil, err
+		}
+		res = append(res, it)
+	}
+	return res, nil
+}
+
+func (r *PostgresRepo) CreateMerchItem(ctx context.Context, name string, price, stock int, description string) (int, error) {
+	query := `INSERT INTO merch_items (name, price, stock, description) VALUES ($1, $2, $3, $4) RETURNING id;`
+	var newID int
+	if err := r.db.QueryRowContext(ctx, query, name, price, stock, description).Scan(&newID); err != nil {
+		return 0, errors.Wrap(err, "repo: CreateMerchItem")
+	}
+	return newID, nil
+}
+
+func (r *PostgresRepo) UpdateMerchItemPrice(ctx context.Context, name string, price int) error {
+	query := `UPDATE merch_items SET price = $1 WHERE name = $2;`
+	res, err := r.db.ExecContext(ctx, query, price, name)
+	if err != nil {
+		return errors.Wrap(err, "repo: UpdateMerchItemPrice")
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return fmt.Errorf("no item updated, item=%s not found", name)
+	}
+	return nil
+}
+
+func (r *PostgresRepo) UpdateMerchItemStock(ctx context.Context, name string, stock int) error {
+	query := `UPDATE merch_items SET stock = $1 WHERE name = $2;`
+	res, err := r.db.ExecContext(ctx, query, stock, name)
+	if err != nil {
+		return errors.Wrap(err, "repo: UpdateMerchItemStock")
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return fmt.Errorf("no item updated, item=%s not found", name)
+	}
+	return nil
+}
+
+func (r *PostgresRepo) SetMerchItemActive(ctx context.Context, name string, active bool) error {
+	query := `UPDATE merch_items SET active = $1 WHERE name = $2;`
+	res, err := r.db.ExecContext(ctx, query, active, name)
+	if err != nil {
+		return errors.Wrap(err, "repo: SetMerchItemActive")
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return fmt.Errorf("no item updated, item=%s not found", name)
+	}
+	return nil
+}
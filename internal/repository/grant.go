@@ -0,0 +1,44 @@
+package repository
+
+import (
+	"context"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	"merchShop/internal/domain"
+)
+
+// GrantCoinsTx mints every grant in a single transaction: either all users
+// are credited or none is. Each grant is its own ledger entry issued by
+// actorID and shows up in the recipient's history as a transfer without a
+// sender.
+func (r *PostgresRepo) GrantCoinsTx(ctx context.Context, actorID int, grants []domain.Grant) error {
+	sorted := make([]domain.Grant, len(grants))
+	copy(sorted, grants)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].UserID < sorted[j].UserID })
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for _, g := range sorted {
+		entryID, err := postEntry(ctx, tx, domain.LedgerEntry{
+			Kind:     domain.EntryGrant,
+			ActorID:  actorID,
+			Postings: domain.Transfer(domain.AccountIssuance, domain.UserAccount(g.UserID), g.Amount),
+		})
+		if err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+		_, err = tx.ExecContext(ctx,
+			"INSERT INTO coin_transactions (from_user_id, to_user_id, amount, memo, ledger_entry_id) VALUES (NULL, $1, $2, $3, $4)",
+			g.UserID, g.Amount, g.Reason, entryID)
+		if err != nil {
+			_ = tx.Rollback()
+			return errors.Wrap(err, "repo: GrantCoinsTx")
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,37 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	"merchShop/internal/migrate"
+	"merchShop/migrations"
+)
+
+func (r *PostgresRepo) Ping(ctx context.Context) error {
+	return errors.Wrap(r.db.PingContext(ctx), "repo: Ping")
+}
+
+// CheckSchema fails while any embedded migration is not applied yet.
+func (r *PostgresRepo) CheckSchema(ctx context.Context) error {
+	m, err := r.Migrator()
+	if err != nil {
+		return err
+	}
+	pending, err := m.Pending(ctx)
+	if err != nil {
+		return errors.Wrap(err, "repo: CheckSchema")
+	}
+	if pending > 0 {
+		return fmt.Errorf("%d migrations pending", pending)
+	}
+	return nil
+}
+
+// Migrator manages the schema of this database with the migrations embedded
+// in the binary.
+func (r *PostgresRepo) Migrator() (*migrate.Migrator, error) {
+	return migrate.New(r.db, migrations.FS)
+}
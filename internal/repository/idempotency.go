@@ -0,0 +1,61 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/pkg/errors"
+
+	"merchShop/internal/domain"
+)
+
+// Keys older than this are forgotten, so a request that crashed mid-flight
+// does not block its key forever.
+const idempotencyKeyTTL = "24 hours"
+
+// ReserveIdempotencyKey claims the key for the user. It returns true when the
+// key is new; otherwise it returns the record stored for the first request.
+func (r *PostgresRepo) ReserveIdempotencyKey(ctx context.Context, userID int, key, requestHash string) (*domain.IdempotencyRecord, bool, error) {
+	_, err := r.db.ExecContext(ctx,
+		"DELETE FROM idempotency_keys WHERE user_id = $1 AND key = $2 AND created_at < NOW() - INTERVAL '"+idempotencyKeyTTL+"'",
+		userID, key)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "repo: ReserveIdempotencyKey")
+	}
+
+	res, err := r.db.ExecContext(ctx, `
+        INSERT INTO idempotency_keys (user_id, key, request_hash)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (user_id, key) DO NOTHING`, userID, key, requestHash)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "repo: ReserveIdempotencyKey")
+	}
+	if rows, _ := res.RowsAffected(); rows == 1 {
+		return nil, true, nil
+	}
+
+	rec := &domain.IdempotencyRecord{}
+	var status sql.NullInt64
+	err = r.db.QueryRowContext(ctx,
+		"SELECT request_hash, status_code, response_body FROM idempotency_keys WHERE user_id = $1 AND key = $2",
+		userID, key).Scan(&rec.RequestHash, &status, &rec.Body)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "repo: ReserveIdempotencyKey")
+	}
+	rec.StatusCode = int(status.Int64)
+	return rec, false, nil
+}
+
+func (r *PostgresRepo) CompleteIdempotencyKey(ctx context.Context, userID int, key string, status int, body []byte) error {
+	_, err := r.db.ExecContext(ctx,
+		"UPDATE idempotency_keys SET status_code = $3, response_body = $4 WHERE user_id = $1 AND key = $2",
+		userID, key, status, body)
+	return errors.Wrap(err, "repo: CompleteIdempotencyKey")
+}
+
+func (r *PostgresRepo) ReleaseIdempotencyKey(ctx context.Context, userID int, key string) error {
+	_, err := r.db.ExecContext(ctx,
+		"DELETE FROM idempotency_keys WHERE user_id = $1 AND key = $2 AND status_code IS NULL",
+		userID, key)
+	return errors.Wrap(err, "repo: ReleaseIdempotencyKey")
+}
@@ -0,0 +1,384 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"merchShop/internal/domain"
+)
+
+// postEntry writes a balanced ledger entry inside tx and applies the postings
+// on user accounts to the users.coins projection. A posting that would take a
+// user below zero fails the whole entry with domain.ErrNotEnoughCoins.
+// Debits consume coin lots oldest-first. Credits open lots: coins passed on by
+// a transfer or returned by a refund keep the dates of the lots they were
+// spent from, everything else (signup, grant, allowance) starts a new lot.
+func postEntry(ctx context.Context, tx *sql.Tx, e domain.LedgerEntry) (int, error) {
+	sum := 0
+	for _, p := range e.Postings {
+		sum += p.Amount
+	}
+	if sum != 0 || len(e.Postings) == 0 {
+		return 0, fmt.Errorf("ledger entry %q is not balanced", e.Kind)
+	}
+
+	type userPosting struct {
+		userID int
+		amount int
+	}
+	var users []userPosting
+
+	var actor sql.NullInt64
+	if e.ActorID != 0 {
+		actor = sql.NullInt64{Int64: int64(e.ActorID), Valid: true}
+	}
+	var entryID int
+	err := tx.QueryRowContext(ctx,
+		"INSERT INTO ledger_entries (kind, actor_id) VALUES ($1, $2) RETURNING id",
+		e.Kind, actor).Scan(&entryID)
+	if err != nil {
+		return 0, errors.Wrap(err, "repo: postEntry")
+	}
+
+	for _, p := range e.Postings {
+		var accountID int
+		var userID sql.NullInt64
+		err := tx.QueryRowContext(ctx,
+			"SELECT id, user_id FROM ledger_accounts WHERE code = $1", p.Account).Scan(&accountID, &userID)
+		if err != nil {
+			return 0, errors.Wrapf(err, "repo: postEntry: account %s", p.Account)
+		}
+		_, err = tx.ExecContext(ctx,
+			"INSERT INTO ledger_postings (entry_id, account_id, amount) VALUES ($1, $2, $3)",
+			entryID, accountID, p.Amount)
+		if err != nil {
+			return 0, errors.Wrap(err, "repo: postEntry")
+		}
+		if userID.Valid {
+			users = append(users, userPosting{userID: int(userID.Int64), amount: p.Amount})
+		}
+	}
+
+	// Lock wallets in id order so two opposite transfers cannot deadlock.
+	sort.Slice(users, func(i, j int) bool { return users[i].userID < users[j].userID })
+	for _, u := range users {
+		res, err := tx.ExecContext(ctx,
+			"UPDATE users SET coins = coins + $1 WHERE id = $2 AND coins + $1 >= 0", u.amount, u.userID)
+		if err != nil {
+			return 0, errors.Wrap(err, "repo: postEntry")
+		}
+		if rows, err := res.RowsAffected(); err != nil || rows == 0 {
+			return 0, domain.ErrNotEnoughCoins
+		}
+	}
+
+	// The wallet rows are locked now, which serializes all lot changes for
+	// these users. Debits go first so a transfer knows the dates of the
+	// coins it passes on.
+	var spent []lotPart
+	for _, u := range users {
+		if u.amount < 0 {
+			parts, err := consumeLots(ctx, tx, entryID, u.userID, -u.amount)
+			if err != nil {
+				return 0, errors.Wrap(err, "repo: postEntry")
+			}
+			spent = append(spent, parts...)
+		}
+	}
+	for _, u := range users {
+		if u.amount <= 0 {
+			continue
+		}
+		var parts []lotPart
+		switch e.Kind {
+		case domain.EntryTransfer:
+			parts, spent = splitParts(spent, u.amount)
+		case domain.EntryRefund:
+			if parts, err = refundedParts(ctx, tx, e.RefundOf, u.amount); err != nil {
+				return 0, errors.Wrap(err, "repo: postEntry")
+			}
+		}
+		if err := openLots(ctx, tx, entryID, u.userID, u.amount, parts); err != nil {
+			return 0, errors.Wrap(err, "repo: postEntry")
+		}
+	}
+	slog.DebugContext(ctx, "ledger entry posted",
+		slog.Int("entry_id", entryID), slog.String("kind", e.Kind), slog.Int("postings", len(e.Postings)))
+	return entryID, nil
+}
+
+// lotPart is an amount of coins together with the date they were first
+// credited.
+type lotPart struct {
+	createdAt time.Time
+	amount    int
+}
+
+// consumeLots takes amount coins from the user's lots, oldest first, records
+// what was taken from each lot against the entry and returns the taken parts
+// oldest first. Coins held without a lot are spent last.
+func consumeLots(ctx context.Context, tx *sql.Tx, entryID, userID, amount int) ([]lotPart, error) {
+	query := `WITH taken AS (
+	              UPDATE coin_lots l SET remaining = l.remaining - c.take
+	              FROM (
+	                  SELECT id, LEAST(remaining, $2 - COALESCE(SUM(remaining) OVER (
+	                             ORDER BY created_at, id ROWS BETWEEN UNBOUNDED PRECEDING AND 1 PRECEDING), 0)) AS take
+	                  FROM coin_lots
+	                  WHERE user_id = $1 AND remaining > 0
+	              ) c
+	              WHERE l.id = c.id AND c.take > 0
+	              RETURNING l.id, l.created_at, c.take
+	          ), spent AS (
+	              INSERT INTO coin_lot_spends (ledger_entry_id, lot_id, amount)
+	              SELECT $3::int, id, take FROM taken
+	          )
+	          SELECT created_at, take FROM taken ORDER BY created_at, id;`
+	rows, err := tx.QueryContext(ctx, query, userID, amount, entryID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []lotPart
+	for rows.Next() {
+		var p lotPart
+		if err := rows.Scan(&p.createdAt, &p.amount); err != nil {
+			return nil, err
+		}
+		res = append(res, p)
+	}
+	return res, rows.Err()
+}
+
+// splitParts takes the oldest parts worth amount coins from parts and returns
+// them together with what is left.
+func splitParts(parts []lotPart, amount int) (taken, rest []lotPart) {
+	for i, p := range parts {
+		if amount == 0 {
+			return taken, parts[i:]
+		}
+		if p.amount > amount {
+			taken = append(taken, lotPart{createdAt: p.createdAt, amount: amount})
+			rest = append([]lotPart{{createdAt: p.createdAt, amount: p.amount - amount}}, parts[i+1:]...)
+			return taken, rest
+		}
+		taken = append(taken, p)
+		amount -= p.amount
+	}
+	return taken, nil
+}
+
+// refundedParts returns up to amount coins spent by the purchase entry that
+// were not refunded yet, oldest first, and marks them as refunded.
+func refundedParts(ctx context.Context, tx *sql.Tx, purchaseEntryID, amount int) ([]lotPart, error) {
+	if purchaseEntryID == 0 {
+		return nil, nil
+	}
+	rows, err := tx.QueryContext(ctx, `
+        SELECT s.id, l.created_at, s.amount - s.refunded
+        FROM coin_lot_spends s
+        JOIN coin_lots l ON l.id = s.lot_id
+        WHERE s.ledger_entry_id = $1 AND s.refunded < s.amount
+        ORDER BY l.created_at, l.id
+        FOR UPDATE OF s`, purchaseEntryID)
+	if err != nil {
+		return nil, err
+	}
+	type spend struct {
+		id int
+		lotPart
+	}
+	var spends []spend
+	for rows.Next() {
+		var s spend
+		if err := rows.Scan(&s.id, &s.createdAt, &s.amount); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		spends = append(spends, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var res []lotPart
+	for _, s := range spends {
+		if amount == 0 {
+			break
+		}
+		take := min(s.amount, amount)
+		if _, err := tx.ExecContext(ctx,
+			"UPDATE coin_lot_spends SET refunded = refunded + $2 WHERE id = $1", s.id, take); err != nil {
+			return nil, err
+		}
+		res = append(res, lotPart{createdAt: s.createdAt, amount: take})
+		amount -= take
+	}
+	return res, nil
+}
+
+// openLots credits amount coins to the user as lots dated like parts. Coins
+// not covered by parts start a new lot.
+func openLots(ctx context.Context, tx *sql.Tx, entryID, userID, amount int, parts []lotPart) error {
+	for _, p := range parts {
+		_, err := tx.ExecContext(ctx,
+			"INSERT INTO coin_lots (user_id, amount, remaining, ledger_entry_id, created_at) VALUES ($1, $2, $2, $3, $4)",
+			userID, p.amount, entryID, p.createdAt)
+		if err != nil {
+			return err
+		}
+		amount -= p.amount
+	}
+	if amount <= 0 {
+		return nil
+	}
+	_, err := tx.ExecContext(ctx,
+		"INSERT INTO coin_lots (user_id, amount, remaining, ledger_entry_id) VALUES ($1, $2, $2, $3)",
+		userID, amount, entryID)
+	return err
+}
+
+func createUserAccount(ctx context.Context, tx *sql.Tx, userID int) error {
+	_, err := tx.ExecContext(ctx,
+		"INSERT INTO ledger_accounts (code, user_id) VALUES ($1, $2)", domain.UserAccount(userID), userID)
+	return errors.Wrap(err, "repo: createUserAccount")
+}
+
+// ListBalanceDrift returns users whose cached balance differs from the sum of
+// the postings on their ledger account.
+func (r *PostgresRepo) ListBalanceDrift(ctx context.Context) ([]domain.BalanceDrift, error) {
+	query := `SELECT u.id, u.username, u.coins, COALESCE(SUM(p.amount), 0)
+	          FROM users u
+	          LEFT JOIN ledger_accounts a ON a.user_id = u.id
+	          LEFT JOIN ledger_postings p ON p.account_id = a.id
+	          GROUP BY u.id
+	          HAVING u.coins <> COALESCE(SUM(p.amount), 0)
+	          ORDER BY u.id;`
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, errors.Wrap(err, "repo: ListBalanceDrift")
+	}
+	defer rows.Close()
+
+	var res []domain.BalanceDrift
+	for rows.Next() {
+		var d domain.BalanceDrift
+		if err := rows.Scan(&d.UserID, &d.Username, &d.Cached, &d.Ledger); err != nil {
+			return nil, err
+		}
+		res = append(res, d)
+	}
+	return res, nil
+}
+
+// ListUnbalancedEntries returns ids of ledger entries whose postings do not
+// sum to zero. The deferred trigger makes this impossible for new entries;
+// the check guards against manual edits.
+func (r *PostgresRepo) ListUnbalancedEntries(ctx context.Context) ([]int, error) {
+	query := `SELECT e.id
+	          FROM ledger_entries e
+	          LEFT JOIN ledger_postings p ON p.entry_id = e.id
+	          GROUP BY e.id
+	          HAVING COALESCE(SUM(p.amount), 0) <> 0 OR COUNT(p.id) = 0
+	          ORDER BY e.id;`
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, errors.Wrap(err, "repo: ListUnbalancedEntries")
+	}
+	defer rows.Close()
+
+	var res []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		res = append(res, id)
+	}
+	return res, nil
+}
+
+// RebuildBalances overwrites users.coins with the ledger balance for every
+// drifted user and returns the number of repaired rows. It refuses to run
+// while a user has no ledger account, since their balance would be reset to
+// zero instead of being rebuilt.
+func (r *PostgresRepo) RebuildBalances(ctx context.Context) (int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	var missing int
+	err = tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM users u
+	          WHERE NOT EXISTS (SELECT 1 FROM ledger_accounts a WHERE a.user_id = u.id);`).Scan(&missing)
+	if err != nil {
+		_ = tx.Rollback()
+		return 0, errors.Wrap(err, "repo: RebuildBalances")
+	}
+	if missing > 0 {
+		_ = tx.Rollback()
+		return 0, fmt.Errorf("%d users: %w", missing, domain.ErrMissingLedgerAccount)
+	}
+
+	query := `UPDATE users u SET coins = b.balance
+	          FROM (
+	              SELECT a.user_id AS id, COALESCE(SUM(p.amount), 0) AS balance
+	              FROM ledger_accounts a
+	              LEFT JOIN ledger_postings p ON p.account_id = a.id
+	              WHERE a.user_id IS NOT NULL
+	              GROUP BY a.user_id
+	          ) b
+	          WHERE u.id = b.id AND u.coins <> b.balance;`
+	res, err := tx.ExecContext(ctx, query)
+	if err != nil {
+		_ = tx.Rollback()
+		return 0, errors.Wrap(err, "repo: RebuildBalances")
+	}
+	rows, _ := res.RowsAffected()
+	return int(rows), tx.Commit()
+}
+
+// ListUserBalances recomputes every user's balance from the ledger and,
+// independently, from the history tables so the two can be cross-checked.
+// Signup bonuses, expiries and forfeits on deletion have no history rows and
+// are taken from the ledger in both. Transfers made before the ledger existed
+// are already part of the opening signup entry and are skipped.
+func (r *PostgresRepo) ListUserBalances(ctx context.Context) ([]domain.UserBalance, error) {
+	query := `SELECT u.id, u.username, u.coins,
+	              COALESCE((SELECT SUM(p.amount)
+	                        FROM ledger_postings p
+	                        JOIN ledger_accounts a ON a.id = p.account_id
+	                        WHERE a.user_id = u.id), 0),
+	              COALESCE((SELECT SUM(p.amount)
+	                        FROM ledger_postings p
+	                        JOIN ledger_accounts a ON a.id = p.account_id
+	                        JOIN ledger_entries e ON e.id = p.entry_id
+	                        WHERE a.user_id = u.id AND e.kind IN ($1, $2, $3)), 0)
+	            + COALESCE((SELECT SUM(amount) FROM coin_transactions WHERE to_user_id = u.id AND ledger_entry_id IS NOT NULL), 0)
+	            - COALESCE((SELECT SUM(amount) FROM coin_transactions WHERE from_user_id = u.id AND ledger_entry_id IS NOT NULL), 0)
+	            - COALESCE((SELECT SUM(quantity * unit_price) FROM purchases WHERE user_id = u.id), 0)
+	            + COALESCE((SELECT SUM(amount) FROM refunds WHERE user_id = u.id), 0)
+	          FROM users u
+	          ORDER BY u.id;`
+	rows, err := r.db.QueryContext(ctx, query, domain.EntrySignup, domain.EntryExpiry, domain.EntryForfeit)
+	if err != nil {
+		return nil, errors.Wrap(err, "repo: ListUserBalances")
+	}
+	defer rows.Close()
+
+	var res []domain.UserBalance
+	for rows.Next() {
+		var b domain.UserBalance
+		if err := rows.Scan(&b.UserID, &b.Username, &b.Cached, &b.Ledger, &b.History); err != nil {
+			return nil, err
+		}
+		res = append(res, b)
+	}
+	return res, nil
+}
@@ -0,0 +1,110 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"merchShop/internal/domain"
+)
+
+// ListOpenLots returns the user's unspent lots credited before the given
+// time, oldest first.
+func (r *PostgresRepo) ListOpenLots(ctx context.Context, userID int, creditedBefore time.Time) ([]domain.CoinLot, error) {
+	query := `SELECT id, user_id, amount, remaining, created_at
+	          FROM coin_lots
+	          WHERE user_id = $1 AND remaining > 0 AND created_at < $2
+	          ORDER BY created_at, id;`
+	rows, err := r.db.QueryContext(ctx, query, userID, creditedBefore)
+	if err != nil {
+		return nil, errors.Wrap(err, "repo: ListOpenLots")
+	}
+	defer rows.Close()
+
+	var res []domain.CoinLot
+	for rows.Next() {
+		var l domain.CoinLot
+		if err := rows.Scan(&l.ID, &l.UserID, &l.Amount, &l.Remaining, &l.CreatedAt); err != nil {
+			return nil, err
+		}
+		res = append(res, l)
+	}
+	return res, nil
+}
+
+// ExpireCoins moves the unspent remainder of every lot credited before the
+// given time to the expired account and returns the number of coins expired.
+// Each user is handled in their own transaction.
+func (r *PostgresRepo) ExpireCoins(ctx context.Context, creditedBefore time.Time) (int, error) {
+	rows, err := r.db.QueryContext(ctx,
+		"SELECT DISTINCT user_id FROM coin_lots WHERE remaining > 0 AND created_at < $1 ORDER BY user_id",
+		creditedBefore)
+	if err != nil {
+		return 0, errors.Wrap(err, "repo: ExpireCoins")
+	}
+	var userIDs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		userIDs = append(userIDs, id)
+	}
+	rows.Close()
+
+	total := 0
+	for _, userID := range userIDs {
+		n, err := r.expireUserCoins(ctx, userID, creditedBefore)
+		if err != nil {
+			return total, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+func (r *PostgresRepo) expireUserCoins(ctx context.Context, userID int, creditedBefore time.Time) (int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	var coins, expired int
+	if err := tx.QueryRowContext(ctx, "SELECT coins FROM users WHERE id = $1 FOR UPDATE", userID).Scan(&coins); err != nil {
+		_ = tx.Rollback()
+		return 0, errors.Wrap(err, "repo: ExpireCoins")
+	}
+	err = tx.QueryRowContext(ctx,
+		"SELECT COALESCE(SUM(remaining), 0) FROM coin_lots WHERE user_id = $1 AND remaining > 0 AND created_at < $2",
+		userID, creditedBefore).Scan(&expired)
+	if err != nil {
+		_ = tx.Rollback()
+		return 0, errors.Wrap(err, "repo: ExpireCoins")
+	}
+	if expired > coins {
+		expired = coins
+	}
+	if expired > 0 {
+		// The expired lots are the oldest ones, so the FIFO debit in
+		// postEntry consumes exactly them.
+		_, err = postEntry(ctx, tx, domain.LedgerEntry{
+			Kind:     domain.EntryExpiry,
+			Postings: domain.Transfer(domain.UserAccount(userID), domain.AccountExpired, expired),
+		})
+		if err != nil {
+			_ = tx.Rollback()
+			return 0, err
+		}
+	}
+	// Close whatever the balance could not cover, so drifted lots do not
+	// come up again on every run.
+	_, err = tx.ExecContext(ctx,
+		"UPDATE coin_lots SET remaining = 0 WHERE user_id = $1 AND remaining > 0 AND created_at < $2",
+		userID, creditedBefore)
+	if err != nil {
+		_ = tx.Rollback()
+		return 0, errors.Wrap(err, "repo: ExpireCoins")
+	}
+	return expired, tx.Commit()
+}
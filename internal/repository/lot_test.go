@@ -0,0 +1,109 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"merchShop/internal/domain"
+	"merchShop/internal/migrate"
+	"merchShop/internal/pgtest"
+	"merchShop/migrations"
+)
+
+const testLifetime = 365 * 24 * time.Hour
+
+func newTestRepo(t *testing.T) *PostgresRepo {
+	db := pgtest.Open(t)
+	m, err := migrate.New(db, migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return &PostgresRepo{db: db}
+}
+
+func createTestUser(t *testing.T, repo *PostgresRepo, name string, bonus int) int {
+	id, err := repo.CreateUser(context.Background(), name, "x", bonus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// backdate pretends every lot of the user was credited at the given time.
+func backdate(t *testing.T, repo *PostgresRepo, userID int, at time.Time) {
+	if _, err := repo.db.Exec("UPDATE coin_lots SET created_at = $2 WHERE user_id = $1", userID, at); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func coinsOf(t *testing.T, repo *PostgresRepo, userID int) int {
+	u, err := repo.GetUserByID(context.Background(), userID)
+	if err != nil || u == nil {
+		t.Fatal(err)
+	}
+	return u.Coins
+}
+
+func TestTransferKeepsLotDates(t *testing.T) {
+	repo := newTestRepo(t)
+	ctx := context.Background()
+	alice := createTestUser(t, repo, "alice", 100)
+	bob := createTestUser(t, repo, "bob", 10)
+	credited := time.Now().Add(-testLifetime + 24*time.Hour).Truncate(time.Second)
+	backdate(t, repo, alice, credited)
+
+	// Passing the coins back and forth must not make them younger.
+	assert.NoError(t, repo.TransferCoins(ctx, alice, bob, 60, ""))
+	lots, err := repo.ListOpenLots(ctx, bob, time.Now())
+	assert.NoError(t, err)
+	if assert.Len(t, lots, 2) {
+		assert.True(t, lots[0].CreatedAt.Equal(credited), "received coins keep the sender's date")
+		assert.Equal(t, 60, lots[0].Remaining)
+	}
+	assert.NoError(t, repo.TransferCoins(ctx, bob, alice, 60, ""))
+
+	// Two days later alice's original coins are past their lifetime.
+	expired, err := repo.ExpireCoins(ctx, time.Now().Add(2*24*time.Hour-testLifetime))
+	assert.NoError(t, err)
+	assert.Equal(t, 100, expired)
+	assert.Equal(t, 0, coinsOf(t, repo, alice))
+	assert.Equal(t, 10, coinsOf(t, repo, bob), "bob's own signup bonus is younger")
+}
+
+func TestRefundKeepsLotDates(t *testing.T) {
+	repo := newTestRepo(t)
+	ctx := context.Background()
+	alice := createTestUser(t, repo, "alice", 100)
+	credited := time.Now().Add(-testLifetime + 24*time.Hour).Truncate(time.Second)
+	backdate(t, repo, alice, credited)
+
+	total, err := repo.BuyMerchTx(ctx, alice, []domain.OrderLine{{ItemName: "cup", Quantity: 2}})
+	assert.NoError(t, err)
+	assert.Equal(t, 40, total)
+	purchases, err := repo.ListUserPurchases(ctx, alice)
+	if !assert.NoError(t, err) || !assert.Len(t, purchases, 1) {
+		return
+	}
+	for i := 0; i < 2; i++ {
+		_, err = repo.RefundPurchaseTx(ctx, domain.Refund{
+			PurchaseID: purchases[0].ID, UserID: alice, Quantity: 1, Amount: 20, RefundedBy: alice,
+		})
+		assert.NoError(t, err)
+	}
+	lots, err := repo.ListOpenLots(ctx, alice, time.Now())
+	assert.NoError(t, err)
+	for _, l := range lots {
+		assert.True(t, l.CreatedAt.Equal(credited), "refunded coins keep the date they were spent from")
+	}
+
+	expired, err := repo.ExpireCoins(ctx, time.Now().Add(2*24*time.Hour-testLifetime))
+	assert.NoError(t, err)
+	assert.Equal(t, 100, expired, "a refund does not restart the expiry clock")
+	assert.Equal(t, 0, coinsOf(t, repo, alice))
+}
@@ -4,9 +4,13 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"sort"
+	"time"
 
+	"github.com/XSAM/otelsql"
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/pkg/errors"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 
 	"merchShop/internal/domain"
 )
@@ -15,31 +19,77 @@ type PostgresRepo struct {
 	db *sql.DB
 }
 
-func NewPostgresRepo(dsn string) (*PostgresRepo, error) {
-	db, err := sql.Open("pgx", dsn)
+// PoolOptions tune the connection pool. Zero values keep the database/sql
+// defaults.
+type PoolOptions struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
+
+func NewPostgresRepo(dsn string, pool PoolOptions) (*PostgresRepo, error) {
+	// Every query becomes a child span of the calling Service method.
+	db, err := otelsql.Open("pgx", dsn,
+		otelsql.WithAttributes(semconv.DBSystemPostgreSQL),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{OmitConnResetSession: true, OmitRows: true}))
 	if err != nil {
 		return nil, fmt.Errorf("cannot open db: %w", err)
 	}
+	if pool.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(pool.MaxOpenConns)
+	}
+	if pool.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(pool.MaxIdleConns)
+	}
+	if pool.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(pool.ConnMaxLifetime)
+	}
 	if err := db.Ping(); err != nil {
 		return nil, fmt.Errorf("cannot ping db: %w", err)
 	}
 	return &PostgresRepo{db: db}, nil
 }
 
-func (r *PostgresRepo) CreateUser(ctx context.Context, username, passwordHash string) (int, error) {
-	query := `INSERT INTO users (username, password_hash, coins) VALUES ($1, $2, 1000) RETURNING id;`
+// DB exposes the connection pool for instrumentation.
+func (r *PostgresRepo) DB() *sql.DB {
+	return r.db
+}
+
+// CreateUser inserts the user with an empty wallet and issues bonus coins to
+// it through the ledger.
+func (r *PostgresRepo) CreateUser(ctx context.Context, username, passwordHash string, bonus int) (int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	query := `INSERT INTO users (username, password_hash, coins) VALUES ($1, $2, 0) RETURNING id;`
 	var newID int
-	if err := r.db.QueryRowContext(ctx, query, username, passwordHash).Scan(&newID); err != nil {
+	if err := tx.QueryRowContext(ctx, query, username, passwordHash).Scan(&newID); err != nil {
+		_ = tx.Rollback()
 		return 0, errors.Wrap(err, "repo: CreateUser")
 	}
-	return newID, nil
+	if err := createUserAccount(ctx, tx, newID); err != nil {
+		_ = tx.Rollback()
+		return 0, err
+	}
+	if bonus > 0 {
+		_, err = postEntry(ctx, tx, domain.LedgerEntry{
+			Kind:     domain.EntrySignup,
+			Postings: domain.Transfer(domain.AccountIssuance, domain.UserAccount(newID), bonus),
+		})
+		if err != nil {
+			_ = tx.Rollback()
+			return 0, err
+		}
+	}
+	return newID, tx.Commit()
 }
 
 func (r *PostgresRepo) GetUserByUsername(ctx context.Context, username string) (*domain.User, error) {
-	query := `SELECT id, username, password_hash, coins FROM users WHERE username = $1;`
+	query := `SELECT id, username, password_hash, coins, role, status FROM users WHERE username = $1;`
 	row := r.db.QueryRowContext(ctx, query, username)
 	u := &domain.User{}
-	if err := row.Scan(&u.ID, &u.Username, &u.PasswordHash, &u.Coins); err != nil {
+	if err := row.Scan(&u.ID, &u.Username, &u.PasswordHash, &u.Coins, &u.Role, &u.Status); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
 		}
@@ -49,10 +99,10 @@ func (r *PostgresRepo) GetUserByUsername(ctx context.Context, username string) (
 }
 
 func (r *PostgresRepo) GetUserByID(ctx context.Context, id int) (*domain.User, error) {
-	query := `SELECT id, username, password_hash, coins FROM users WHERE id = $1;`
+	query := `SELECT id, username, password_hash, coins, role, status FROM users WHERE id = $1;`
 	row := r.db.QueryRowContext(ctx, query, id)
 	u := &domain.User{}
-	if err := row.Scan(&u.ID, &u.Username, &u.PasswordHash, &u.Coins); err != nil {
+	if err := row.Scan(&u.ID, &u.Username, &u.PasswordHash, &u.Coins, &u.Role, &u.Status); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
 		}
@@ -61,32 +111,37 @@ func (r *PostgresRepo) GetUserByID(ctx context.Context, id int) (*domain.User, e
 	return u, nil
 }
 
-func (r *PostgresRepo) UpdateUserCoins(ctx context.Context, userID int, newCoins int) error {
-	query := `UPDATE users SET coins = $1 WHERE id = $2;`
-	res, err := r.db.ExecContext(ctx, query, newCoins, userID)
+func (r *PostgresRepo) SetUserRole(ctx context.Context, userID int, role domain.Role) error {
+	query := `UPDATE users SET role = $1 WHERE id = $2;`
+	res, err := r.db.ExecContext(ctx, query, role, userID)
 	if err != nil {
-		return errors.Wrap(err, "repo: UpdateUserCoins")
+		return errors.Wrap(err, "repo: SetUserRole")
 	}
 	if rows, _ := res.RowsAffected(); rows == 0 {
-		return fmt.Errorf("no user updated, user_id=%d not found", userID)
+		return fmt.Errorf("no user updated, id=%d not found", userID)
 	}
 	return nil
 }
 
-func (r *PostgresRepo) CreateTransaction(ctx context.Context, fromID, toID, amount int) error {
-	query := `INSERT INTO coin_transactions (from_user_id, to_user_id, amount) VALUES ($1, $2, $3);`
-	_, err := r.db.ExecContext(ctx, query, fromID, toID, amount)
+func (r *PostgresRepo) SetUserStatus(ctx context.Context, userID int, status domain.UserStatus) error {
+	query := `UPDATE users SET status = $1 WHERE id = $2;`
+	res, err := r.db.ExecContext(ctx, query, status, userID)
 	if err != nil {
-		return errors.Wrap(err, "repo: CreateTransaction")
+		return errors.Wrap(err, "repo: SetUserStatus")
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return fmt.Errorf("no user updated, id=%d not found", userID)
 	}
 	return nil
 }
 
+// ListSentTransactions returns every transfer the user made, newest first.
+// /api/info shows the history in full; GET /api/transactions pages it.
 func (r *PostgresRepo) ListSentTransactions(ctx context.Context, userID int) ([]domain.CoinTransaction, error) {
-	query := `SELECT id, from_user_id, to_user_id, amount, created_at
+	query := `SELECT id, from_user_id, to_user_id, amount, memo, created_at
 	          FROM coin_transactions 
 			  WHERE from_user_id = $1
-	          ORDER BY created_at DESC LIMIT 100;`
+	          ORDER BY created_at DESC, id DESC;`
 	rows, err := r.db.QueryContext(ctx, query, userID)
 	if err != nil {
 		return nil, errors.Wrap(err, "repo: ListSentTransactions")
@@ -96,7 +151,7 @@ func (r *PostgresRepo) ListSentTransactions(ctx context.Context, userID int) ([]
 	var res []domain.CoinTransaction
 	for rows.Next() {
 		var t domain.CoinTransaction
-		if err := rows.Scan(&t.ID, &t.FromUserID, &t.ToUserID, &t.Amount, &t.CreatedAt); err != nil {
+		if err := rows.Scan(&t.ID, &t.FromUserID, &t.ToUserID, &t.Amount, &t.Memo, &t.CreatedAt); err != nil {
 			return nil, err
 		}
 		res = append(res, t)
@@ -104,11 +159,12 @@ func (r *PostgresRepo) ListSentTransactions(ctx context.Context, userID int) ([]
 	return res, nil
 }
 
+// ListReceivedTransactions returns every transfer to the user, newest first.
 func (r *PostgresRepo) ListReceivedTransactions(ctx context.Context, userID int) ([]domain.CoinTransaction, error) {
-	query := `SELECT id, from_user_id, to_user_id, amount, created_at
+	query := `SELECT id, COALESCE(from_user_id, 0), to_user_id, amount, memo, created_at
 	          FROM coin_transactions 
 			  WHERE to_user_id = $1
-	          ORDER BY created_at DESC LIMIT 100;`
+	          ORDER BY created_at DESC, id DESC;`
 	rows, err := r.db.QueryContext(ctx, query, userID)
 	if err != nil {
 		return nil, errors.Wrap(err, "repo: ListReceivedTransactions")
@@ -118,7 +174,7 @@ func (r *PostgresRepo) ListReceivedTransactions(ctx context.Context, userID int)
 	var res []domain.CoinTransaction
 	for rows.Next() {
 		var t domain.CoinTransaction
-		if err := rows.Scan(&t.ID, &t.FromUserID, &t.ToUserID, &t.Amount, &t.CreatedAt); err != nil {
+		if err := rows.Scan(&t.ID, &t.FromUserID, &t.ToUserID, &t.Amount, &t.Memo, &t.CreatedAt); err != nil {
 			return nil, err
 		}
 		res = append(res, t)
@@ -143,7 +199,7 @@ func (r *PostgresRepo) AddItemToUser(ctx context.Context, userID int, itemName s
 func (r *PostgresRepo) ListUserInventory(ctx context.Context, userID int) ([]domain.UserInventory, error) {
 	query := `SELECT id, user_id, item_name, quantity, created_at
 	          FROM user_inventory
-	          WHERE user_id = $1
+	          WHERE user_id = $1 AND quantity > 0
 	          ORDER BY item_name;`
 	rows, err := r.db.QueryContext(ctx, query, userID)
 	if err != nil {
@@ -162,27 +218,47 @@ func (r *PostgresRepo) ListUserInventory(ctx context.Context, userID int) ([]dom
 	return res, nil
 }
 
-func (r *PostgresRepo) TransferCoins(ctx context.Context, fromID, toID, amount int) error {
-	tx, err := r.db.BeginTx(ctx, nil)
+// ListUserPurchases returns the whole purchase history of the user, newest
+// first. /api/info shows it in full, so it is not capped.
+func (r *PostgresRepo) ListUserPurchases(ctx context.Context, userID int) ([]domain.Purchase, error) {
+	query := `SELECT id, user_id, item_name, quantity, unit_price, refunded_quantity, created_at
+	          FROM purchases
+	          WHERE user_id = $1
+	          ORDER BY created_at DESC, id DESC;`
+	rows, err := r.db.QueryContext(ctx, query, userID)
 	if err != nil {
-		return err
+		return nil, errors.Wrap(err, "repo: ListUserPurchases")
 	}
-	res, err := tx.ExecContext(ctx, "UPDATE users SET coins = coins - $1 WHERE id = $2 AND coins >= $1", amount, fromID)
+	defer rows.Close()
+
+	var res []domain.Purchase
+	for rows.Next() {
+		var p domain.Purchase
+		if err := rows.Scan(&p.ID, &p.UserID, &p.ItemName, &p.Quantity, &p.UnitPrice, &p.RefundedQuantity, &p.CreatedAt); err != nil {
+			return nil, err
+		}
+		res = append(res, p)
+	}
+	return res, nil
+}
+
+func (r *PostgresRepo) TransferCoins(ctx context.Context, fromID, toID, amount int, memo string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
-		_ = tx.Rollback()
 		return err
 	}
-	rows, err := res.RowsAffected()
-	if err != nil || rows == 0 {
-		_ = tx.Rollback()
-		return errors.New("insufficient funds or sender not found")
-	}
-	_, err = tx.ExecContext(ctx, "UPDATE users SET coins = coins + $1 WHERE id = $2", amount, toID)
+	entryID, err := postEntry(ctx, tx, domain.LedgerEntry{
+		Kind:     domain.EntryTransfer,
+		ActorID:  fromID,
+		Postings: domain.Transfer(domain.UserAccount(fromID), domain.UserAccount(toID), amount),
+	})
 	if err != nil {
 		_ = tx.Rollback()
 		return err
 	}
-	_, err = tx.ExecContext(ctx, "INSERT INTO coin_transactions (from_user_id, to_user_id, amount) VALUES ($1, $2, $3)", fromID, toID, amount)
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO coin_transactions (from_user_id, to_user_id, amount, memo, ledger_entry_id) VALUES ($1, $2, $3, $4, $5)",
+		fromID, toID, amount, memo, entryID)
 	if err != nil {
 		_ = tx.Rollback()
 		return err
@@ -190,33 +266,87 @@ func (r *PostgresRepo) TransferCoins(ctx context.Context, fromID, toID, amount i
 	return tx.Commit()
 }
 
-func (r *PostgresRepo) BuyMerchTx(ctx context.Context, userID int, itemName string, price int) error {
+// BuyMerchTx reserves stock for every line, debits the order total, adds the
+// items to the user's inventory and records the purchases in a single
+// transaction. Lines are processed in item name order so concurrent orders
+// lock rows consistently.
+func (r *PostgresRepo) BuyMerchTx(ctx context.Context, userID int, lines []domain.OrderLine) (int, error) {
+	sorted := make([]domain.OrderLine, len(lines))
+	copy(sorted, lines)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ItemName < sorted[j].ItemName })
+
 	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
-		return err
-	}
-	res, err := tx.ExecContext(ctx, "UPDATE users SET coins = coins - $1 WHERE id = $2 AND coins >= $1", price, userID)
-	if err != nil {
-		_ = tx.Rollback()
-		return err
-	}
-	rows, err := res.RowsAffected()
-	if err != nil || rows == 0 {
-		_ = tx.Rollback()
-		return errors.New("insufficient funds or user not found")
+		return 0, err
 	}
 
-	query := `
+	total := 0
+	for i, l := range sorted {
+		// The stock check and decrement happen in one statement, so concurrent
+		// buyers are serialized on the item row and stock never goes negative.
+		var price int
+		err = tx.QueryRowContext(ctx,
+			"UPDATE merch_items SET stock = stock - $2 WHERE name = $1 AND active AND stock >= $2 RETURNING price",
+			l.ItemName, l.Quantity).Scan(&price)
+		if err == sql.ErrNoRows {
+			err = unavailableItem(ctx, tx, l.ItemName)
+		}
+		if err != nil {
+			_ = tx.Rollback()
+			return 0, err
+		}
+		total += price * l.Quantity
+
+		query := `
         INSERT INTO user_inventory (user_id, item_name, quantity)
-        VALUES ($1, $2, 1)
+        VALUES ($1, $2, $3)
         ON CONFLICT (user_id, item_name) DO UPDATE
-        SET quantity = user_inventory.quantity + 1;
+        SET quantity = user_inventory.quantity + EXCLUDED.quantity;
     `
-	_, err = tx.ExecContext(ctx, query, userID, itemName)
+		_, err = tx.ExecContext(ctx, query, userID, l.ItemName, l.Quantity)
+		if err != nil {
+			_ = tx.Rollback()
+			return 0, err
+		}
+		sorted[i].UnitPrice = price
+	}
+
+	entryID, err := postEntry(ctx, tx, domain.LedgerEntry{
+		Kind:     domain.EntryPurchase,
+		ActorID:  userID,
+		Postings: domain.Transfer(domain.UserAccount(userID), domain.AccountShopRevenue, total),
+	})
 	if err != nil {
 		_ = tx.Rollback()
-		return err
+		return 0, err
 	}
 
-	return tx.Commit()
+	for _, l := range sorted {
+		_, err = tx.ExecContext(ctx,
+			"INSERT INTO purchases (user_id, item_name, quantity, unit_price, ledger_entry_id) VALUES ($1, $2, $3, $4, $5)",
+			userID, l.ItemName, l.Quantity, l.UnitPrice, entryID)
+		if err != nil {
+			_ = tx.Rollback()
+			return 0, err
+		}
+	}
+
+	return total, tx.Commit()
+}
+
+// unavailableItem explains why the stock decrement in BuyMerchTx matched no
+// row. The item may have been retired or removed after the service priced
+// the order, so it is re-read in the same transaction.
+func unavailableItem(ctx context.Context, tx *sql.Tx, name string) error {
+	var active bool
+	err := tx.QueryRowContext(ctx, "SELECT active FROM merch_items WHERE name = $1", name).Scan(&active)
+	switch {
+	case err == sql.ErrNoRows:
+		return domain.ErrUnknownItem
+	case err != nil:
+		return errors.Wrap(err, "repo: unavailableItem")
+	case !active:
+		return domain.ErrItemRetired
+	}
+	return domain.ErrOutOfStock
 }
@@ -0,0 +1,89 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/pkg/errors"
+
+	"merchShop/internal/domain"
+)
+
+func (r *PostgresRepo) GetPurchase(ctx context.Context, id int) (*domain.Purchase, error) {
+	query := `SELECT id, user_id, item_name, quantity, unit_price, refunded_quantity, created_at
+	          FROM purchases WHERE id = $1;`
+	row := r.db.QueryRowContext(ctx, query, id)
+	p := &domain.Purchase{}
+	if err := row.Scan(&p.ID, &p.UserID, &p.ItemName, &p.Quantity, &p.UnitPrice, &p.RefundedQuantity, &p.CreatedAt); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "repo: GetPurchase")
+	}
+	return p, nil
+}
+
+// RefundPurchaseTx takes the units back from the inventory, returns them to
+// stock, credits the coins and writes the refund record atomically. The
+// purchase row is locked so concurrent refunds cannot exceed the quantity.
+func (r *PostgresRepo) RefundPurchaseTx(ctx context.Context, refund domain.Refund) (int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	var itemName string
+	var purchaseEntry sql.NullInt64
+	res := tx.QueryRowContext(ctx, `
+        UPDATE purchases SET refunded_quantity = refunded_quantity + $2
+        WHERE id = $1 AND user_id = $3 AND quantity - refunded_quantity >= $2
+        RETURNING item_name, ledger_entry_id`, refund.PurchaseID, refund.Quantity, refund.UserID)
+	if err = res.Scan(&itemName, &purchaseEntry); err != nil {
+		_ = tx.Rollback()
+		if err == sql.ErrNoRows {
+			return 0, domain.ErrNothingToRefund
+		}
+		return 0, err
+	}
+
+	inv, err := tx.ExecContext(ctx,
+		"UPDATE user_inventory SET quantity = quantity - $3 WHERE user_id = $1 AND item_name = $2 AND quantity >= $3",
+		refund.UserID, itemName, refund.Quantity)
+	if err != nil {
+		_ = tx.Rollback()
+		return 0, err
+	}
+	if rows, err := inv.RowsAffected(); err != nil || rows == 0 {
+		_ = tx.Rollback()
+		return 0, domain.ErrNothingToRefund
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE merch_items SET stock = stock + $2 WHERE name = $1", itemName, refund.Quantity)
+	if err != nil {
+		_ = tx.Rollback()
+		return 0, err
+	}
+
+	entryID, err := postEntry(ctx, tx, domain.LedgerEntry{
+		Kind:     domain.EntryRefund,
+		ActorID:  refund.RefundedBy,
+		Postings: domain.Transfer(domain.AccountShopRevenue, domain.UserAccount(refund.UserID), refund.Amount),
+		RefundOf: int(purchaseEntry.Int64),
+	})
+	if err != nil {
+		_ = tx.Rollback()
+		return 0, err
+	}
+
+	var refundID int
+	err = tx.QueryRowContext(ctx, `
+        INSERT INTO refunds (purchase_id, user_id, quantity, amount, admin_override, refunded_by, ledger_entry_id)
+        VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`,
+		refund.PurchaseID, refund.UserID, refund.Quantity, refund.Amount, refund.AdminOverride, refund.RefundedBy, entryID).Scan(&refundID)
+	if err != nil {
+		_ = tx.Rollback()
+		return 0, err
+	}
+
+	return refundID, tx.Commit()
+}
@@ -0,0 +1,90 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"merchShop/internal/domain"
+)
+
+func (r *PostgresRepo) CreateRefreshToken(ctx context.Context, t domain.RefreshToken) error {
+	_, err := r.db.ExecContext(ctx,
+		"INSERT INTO refresh_tokens (user_id, token_hash, family_id, expires_at) VALUES ($1, $2, $3, $4)",
+		t.UserID, t.TokenHash, t.FamilyID, t.ExpiresAt)
+	return errors.Wrap(err, "repo: CreateRefreshToken")
+}
+
+func (r *PostgresRepo) GetRefreshToken(ctx context.Context, tokenHash string) (*domain.RefreshToken, error) {
+	query := `SELECT id, user_id, token_hash, family_id, expires_at, revoked_at, created_at
+	          FROM refresh_tokens WHERE token_hash = $1;`
+	t := &domain.RefreshToken{}
+	var revokedAt sql.NullTime
+	err := r.db.QueryRowContext(ctx, query, tokenHash).
+		Scan(&t.ID, &t.UserID, &t.TokenHash, &t.FamilyID, &t.ExpiresAt, &revokedAt, &t.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "repo: GetRefreshToken")
+	}
+	if revokedAt.Valid {
+		t.RevokedAt = &revokedAt.Time
+	}
+	return t, nil
+}
+
+// RotateRefreshToken revokes the old token and stores its successor. If the
+// old token was revoked concurrently, domain.ErrRefreshTokenReused is returned
+// and nothing is stored.
+func (r *PostgresRepo) RotateRefreshToken(ctx context.Context, oldID int, next domain.RefreshToken) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	res, err := tx.ExecContext(ctx,
+		"UPDATE refresh_tokens SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL", oldID)
+	if err != nil {
+		_ = tx.Rollback()
+		return errors.Wrap(err, "repo: RotateRefreshToken")
+	}
+	if rows, err := res.RowsAffected(); err != nil || rows == 0 {
+		_ = tx.Rollback()
+		return domain.ErrRefreshTokenReused
+	}
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO refresh_tokens (user_id, token_hash, family_id, expires_at) VALUES ($1, $2, $3, $4)",
+		next.UserID, next.TokenHash, next.FamilyID, next.ExpiresAt)
+	if err != nil {
+		_ = tx.Rollback()
+		return errors.Wrap(err, "repo: RotateRefreshToken")
+	}
+	return tx.Commit()
+}
+
+func (r *PostgresRepo) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	_, err := r.db.ExecContext(ctx,
+		"UPDATE refresh_tokens SET revoked_at = NOW() WHERE family_id = $1 AND revoked_at IS NULL", familyID)
+	return errors.Wrap(err, "repo: RevokeRefreshTokenFamily")
+}
+
+func (r *PostgresRepo) RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	// Entries are only needed until the token would have expired anyway.
+	if _, err := r.db.ExecContext(ctx, "DELETE FROM revoked_tokens WHERE expires_at < NOW()"); err != nil {
+		return errors.Wrap(err, "repo: RevokeAccessToken")
+	}
+	_, err := r.db.ExecContext(ctx,
+		"INSERT INTO revoked_tokens (jti, expires_at) VALUES ($1, $2) ON CONFLICT (jti) DO NOTHING", jti, expiresAt)
+	return errors.Wrap(err, "repo: RevokeAccessToken")
+}
+
+func (r *PostgresRepo) IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	var exists bool
+	err := r.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1)", jti).Scan(&exists)
+	if err != nil {
+		return false, errors.Wrap(err, "repo: IsAccessTokenRevoked")
+	}
+	return exists, nil
+}
@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"merchShop/internal/domain"
+)
+
+// ListTransactions returns the user's transfers matching f, newest first.
+func (r *PostgresRepo) ListTransactions(ctx context.Context, f domain.TransactionFilter) ([]domain.CoinTransaction, error) {
+	var where []string
+	args := []interface{}{f.UserID}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	switch f.Direction {
+	case domain.DirectionSent:
+		where = append(where, "from_user_id = $1")
+		if f.CounterpartyID != 0 {
+			where = append(where, "to_user_id = "+arg(f.CounterpartyID))
+		}
+	case domain.DirectionReceived:
+		where = append(where, "to_user_id = $1")
+		if f.CounterpartyID != 0 {
+			where = append(where, "from_user_id = "+arg(f.CounterpartyID))
+		}
+	default:
+		if f.CounterpartyID != 0 {
+			c := arg(f.CounterpartyID)
+			where = append(where, fmt.Sprintf(
+				"((from_user_id = $1 AND to_user_id = %s) OR (to_user_id = $1 AND from_user_id = %s))", c, c))
+		} else {
+			where = append(where, "(from_user_id = $1 OR to_user_id = $1)")
+		}
+	}
+	if !f.From.IsZero() {
+		where = append(where, "created_at >= "+arg(f.From))
+	}
+	if !f.To.IsZero() {
+		where = append(where, "created_at < "+arg(f.To))
+	}
+	if f.BeforeID != 0 {
+		where = append(where, "id < "+arg(f.BeforeID))
+	}
+
+	query := `SELECT id, COALESCE(from_user_id, 0), to_user_id, amount, memo, created_at
+	          FROM coin_transactions
+	          WHERE ` + strings.Join(where, " AND ") + `
+	          ORDER BY id DESC LIMIT ` + arg(f.Limit) + `;`
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "repo: ListTransactions")
+	}
+	defer rows.Close()
+
+	var res []domain.CoinTransaction
+	for rows.Next() {
+		var t domain.CoinTransaction
+		if err := rows.Scan(&t.ID, &t.FromUserID, &t.ToUserID, &t.Amount, &t.Memo, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		res = append(res, t)
+	}
+	return res, nil
+}
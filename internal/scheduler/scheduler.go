@@ -0,0 +1,111 @@
+// Package scheduler runs background jobs once per calendar period.
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Schedule is a cron-style descriptor naming how often a job runs. Periods
+// are calendar periods in UTC; weeks start on Monday.
+type Schedule string
+
+const (
+	Hourly  Schedule = "@hourly"
+	Daily   Schedule = "@daily"
+	Weekly  Schedule = "@weekly"
+	Monthly Schedule = "@monthly"
+)
+
+// retryInterval is how soon a failed run is retried within the same period.
+const retryInterval = time.Minute
+
+func ParseSchedule(s string) (Schedule, error) {
+	switch sch := Schedule(s); sch {
+	case Hourly, Daily, Weekly, Monthly:
+		return sch, nil
+	}
+	return "", fmt.Errorf("unsupported schedule %q: use @hourly, @daily, @weekly or @monthly", s)
+}
+
+// Start returns the beginning of the period containing t.
+func (s Schedule) Start(t time.Time) time.Time {
+	t = t.UTC()
+	switch s {
+	case Hourly:
+		return t.Truncate(time.Hour)
+	case Weekly:
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	case Monthly:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	}
+}
+
+// Next returns the beginning of the period after the one containing t.
+func (s Schedule) Next(t time.Time) time.Time {
+	start := s.Start(t)
+	switch s {
+	case Hourly:
+		return start.Add(time.Hour)
+	case Weekly:
+		return start.AddDate(0, 0, 7)
+	case Monthly:
+		return start.AddDate(0, 1, 0)
+	default:
+		return start.AddDate(0, 0, 1)
+	}
+}
+
+// Period returns a stable key for the period containing t, e.g. "2024-05" for
+// @monthly. Jobs use it to make their effects idempotent per period.
+func (s Schedule) Period(t time.Time) string {
+	start := s.Start(t)
+	switch s {
+	case Hourly:
+		return start.Format("2006-01-02T15")
+	case Weekly:
+		year, week := start.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	case Monthly:
+		return start.Format("2006-01")
+	default:
+		return start.Format("2006-01-02")
+	}
+}
+
+// Job is called with the key of the current period.
+type Job func(ctx context.Context, period string) error
+
+// Run calls job right away, to catch up on a period missed while the process
+// was down, and then at the start of every period until ctx is cancelled. A
+// failed run is retried until it succeeds or the period ends, so job must be
+// idempotent per period.
+func Run(ctx context.Context, name string, s Schedule, job Job) {
+	for {
+		now := time.Now()
+		wait := s.Next(now).Sub(now)
+		if err := job(ctx, s.Period(now)); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			slog.ErrorContext(ctx, "scheduled job failed", slog.String("job", name),
+				slog.String("period", s.Period(now)), slog.String("error", err.Error()))
+			if wait > retryInterval {
+				wait = retryInterval
+			}
+		}
+
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+		}
+	}
+}
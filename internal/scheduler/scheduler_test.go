@@ -0,0 +1,36 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchedule_Period(t *testing.T) {
+	// Wednesday.
+	now := time.Date(2024, time.May, 15, 13, 45, 0, 0, time.UTC)
+
+	assert.Equal(t, "2024-05-15T13", Hourly.Period(now))
+	assert.Equal(t, "2024-05-15", Daily.Period(now))
+	assert.Equal(t, "2024-W20", Weekly.Period(now))
+	assert.Equal(t, "2024-05", Monthly.Period(now))
+
+	assert.Equal(t, time.Date(2024, time.May, 13, 0, 0, 0, 0, time.UTC), Weekly.Start(now))
+	assert.Equal(t, time.Date(2024, time.May, 20, 0, 0, 0, 0, time.UTC), Weekly.Next(now))
+	assert.Equal(t, time.Date(2024, time.June, 1, 0, 0, 0, 0, time.UTC), Monthly.Next(now))
+	assert.Equal(t, time.Date(2024, time.May, 16, 0, 0, 0, 0, time.UTC), Daily.Next(now))
+
+	// Sunday still belongs to the week that started on Monday.
+	sunday := time.Date(2024, time.May, 19, 23, 0, 0, 0, time.UTC)
+	assert.Equal(t, "2024-W20", Weekly.Period(sunday))
+}
+
+func TestParseSchedule(t *testing.T) {
+	s, err := ParseSchedule("@monthly")
+	assert.NoError(t, err)
+	assert.Equal(t, Monthly, s)
+
+	_, err = ParseSchedule("0 0 1 * *")
+	assert.Error(t, err)
+}
@@ -2,7 +2,7 @@ package server
 
 import (
 	"context"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
@@ -11,27 +11,55 @@ import (
 
 	"github.com/go-chi/chi/v5"
 	"merchShop/internal/handler"
+	"merchShop/internal/metrics"
 )
 
-func StartHTTPServer(srv *http.Server) {
+// StartHTTPServer serves until SIGINT or SIGTERM. On a signal it calls drain
+// so readiness probes start failing, waits drainDelay for load balancers to
+// notice, and only then shuts the server down, giving in-flight requests up
+// to shutdownTimeout to finish.
+func StartHTTPServer(srv *http.Server, drain func(), drainDelay, shutdownTimeout time.Duration) {
 	go func() {
-		log.Printf("HTTP server starting on %s\n", srv.Addr)
+		slog.Info("HTTP server starting", slog.String("addr", srv.Addr))
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("ListenAndServe error: %v\n", err)
+			slog.Error("ListenAndServe error", slog.String("error", err.Error()))
+			os.Exit(1)
 		}
 	}()
 
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
 	<-stop
-	log.Println("Shutting down server...")
+	slog.Info("shutting down server", slog.Duration("drain_delay", drainDelay))
+	drain()
+	time.Sleep(drainDelay)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
 	defer cancel()
 	if err := srv.Shutdown(ctx); err != nil {
-		log.Printf("Server forced to shutdown: %v", err)
+		slog.Error("server forced to shutdown", slog.String("error", err.Error()))
 	}
-	log.Println("Server exiting")
+	slog.Info("server exiting")
+}
+
+// StartMetricsServer serves /metrics on addr in the background. It is kept off
+// the public API router because the endpoint has no authentication; bind it
+// to an address only the monitoring network can reach.
+func StartMetricsServer(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		slog.Info("metrics server starting", slog.String("addr", addr))
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			slog.Error("metrics ListenAndServe error", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+	}()
 }
 
 func NewRouter(h *handler.Handler) http.Handler {
@@ -0,0 +1,77 @@
+// Package tracing installs the process-wide OpenTelemetry tracer provider.
+// The HTTP router, the usecase layer and the SQL driver all create spans
+// through the global provider, so with the "none" exporter they cost next
+// to nothing.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// ServiceName identifies this service in exported spans.
+const ServiceName = "merch-shop"
+
+// Exporter selects where finished spans are sent.
+type Exporter string
+
+const (
+	// ExporterNone keeps trace context propagation but exports nothing.
+	ExporterNone Exporter = "none"
+	// ExporterStdout prints spans as JSON, which is handy in tests. Spans go
+	// to stderr so they do not interleave with the JSON logs on stdout.
+	ExporterStdout Exporter = "stdout"
+	// ExporterOTLP sends spans over OTLP/HTTP. The collector address is
+	// taken from the standard OTEL_EXPORTER_OTLP_ENDPOINT variables.
+	ExporterOTLP Exporter = "otlp"
+)
+
+func ParseExporter(s string) (Exporter, error) {
+	switch e := Exporter(s); e {
+	case ExporterNone, ExporterStdout, ExporterOTLP:
+		return e, nil
+	}
+	return "", fmt.Errorf("unknown trace exporter %q, want none, stdout or otlp", s)
+}
+
+// Setup installs the W3C trace context propagator and a tracer provider
+// for exporter. The returned function flushes pending spans and must be
+// called before the process exits.
+func Setup(ctx context.Context, exporter Exporter) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{}))
+
+	var (
+		exp sdktrace.SpanExporter
+		err error
+	)
+	switch exporter {
+	case ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		exp, err = stdouttrace.New(stdouttrace.WithWriter(os.Stderr))
+	case ExporterOTLP:
+		exp, err = otlptracehttp.New(ctx)
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot create %s trace exporter: %w", exporter, err)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(ServiceName))),
+	)
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
@@ -0,0 +1,66 @@
+package usecase
+
+import (
+	"context"
+	"log/slog"
+
+	"golang.org/x/crypto/bcrypt"
+	"merchShop/internal/domain"
+)
+
+// deletedCounterparty replaces the name of a deleted account in histories.
+const deletedCounterparty = "deleted user"
+
+// DeleteAccount anonymises the caller's own account after re-checking the
+// password. Any remaining coins are forfeited.
+func (s *Service) DeleteAccount(ctx context.Context, userID int, password string) (err error) {
+	ctx, span := tracer.Start(ctx, "Service.DeleteAccount")
+	defer func() { endSpan(span, err) }()
+	user, err := s.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user == nil || user.Status == domain.StatusDeleted {
+		return ErrUserNotFound
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		return ErrInvalidCredentials
+	}
+	return s.deleteUser(ctx, user, user.ID)
+}
+
+// DeleteUser anonymises username on behalf of an admin handling a data
+// removal request. Admins cannot delete themselves this way, so the last
+// admin cannot remove the only account able to manage the others.
+func (s *Service) DeleteUser(ctx context.Context, actorID int, username string) (err error) {
+	ctx, span := tracer.Start(ctx, "Service.DeleteUser")
+	defer func() { endSpan(span, err) }()
+	user, err := s.repo.GetUserByUsername(ctx, username)
+	if err != nil {
+		return err
+	}
+	if user == nil || user.Status == domain.StatusDeleted {
+		return ErrUserNotFound
+	}
+	if user.ID == actorID {
+		return ErrDeleteSelf
+	}
+	return s.deleteUser(ctx, user, actorID)
+}
+
+func (s *Service) deleteUser(ctx context.Context, user *domain.User, actorID int) error {
+	if err := s.repo.DeleteUserTx(ctx, user.ID, actorID); err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "account deleted",
+		slog.Int("deleted_user_id", user.ID), slog.Int("actor_id", actorID), slog.Int("forfeited", user.Coins))
+	return nil
+}
+
+// counterpartyName is how another user is shown in someone's history.
+func counterpartyName(u *domain.User) string {
+	if u == nil || u.Status == domain.StatusDeleted {
+		return deletedCounterparty
+	}
+	return u.Username
+}
@@ -0,0 +1,16 @@
+package usecase
+
+import (
+	"context"
+)
+
+// PayAllowance credits the configured allowance for period to every user who
+// has not received it yet. It is safe to call repeatedly for the same period.
+func (s *Service) PayAllowance(ctx context.Context, period string) (_ int, err error) {
+	ctx, span := tracer.Start(ctx, "Service.PayAllowance")
+	defer func() { endSpan(span, err) }()
+	if s.cfg.AllowanceAmount <= 0 {
+		return 0, nil
+	}
+	return s.repo.PayAllowance(ctx, period, s.cfg.AllowanceAmount, "allowance "+period)
+}
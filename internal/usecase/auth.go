@@ -0,0 +1,99 @@
+package usecase
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+	"merchShop/internal/domain"
+	"merchShop/internal/metrics"
+)
+
+var (
+	ErrUserExists         = errors.New("username is already taken")
+	ErrInvalidUsername    = errors.New("username is not allowed")
+	ErrInvalidInviteCode  = errors.New("invalid invite code")
+	ErrRegistrationClosed = errors.New("registration requires an invite code")
+)
+
+// Register creates a new account. It never logs into an existing one, so a
+// mistyped username is reported instead of silently creating a new user.
+func (s *Service) Register(ctx context.Context, username, password, inviteCode string) (_ *domain.User, err error) {
+	ctx, span := tracer.Start(ctx, "Service.Register")
+	defer func() { endSpan(span, err) }()
+	if err := s.validateUsername(username); err != nil {
+		return nil, err
+	}
+	if len(s.cfg.InviteCodes) > 0 && !s.validInviteCode(inviteCode) {
+		return nil, ErrInvalidInviteCode
+	}
+	existing, err := s.repo.GetUserByUsername(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, ErrUserExists
+	}
+	return s.createUser(ctx, username, password)
+}
+
+// Login authenticates an existing account. Unknown usernames and wrong
+// passwords produce the same error.
+func (s *Service) Login(ctx context.Context, username, password string) (_ *domain.User, err error) {
+	ctx, span := tracer.Start(ctx, "Service.Login")
+	defer func() { endSpan(span, err) }()
+	user, err := s.repo.GetUserByUsername(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrInvalidCredentials
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	if user.Status == domain.StatusFrozen {
+		return nil, ErrAccountFrozen
+	}
+	return user, nil
+}
+
+func (s *Service) createUser(ctx context.Context, username, password string) (*domain.User, error) {
+	if err := validatePassword(password); err != nil {
+		return nil, err
+	}
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %w", err)
+	}
+	newID, err := s.repo.CreateUser(ctx, username, string(hashed), s.cfg.SignupBonus)
+	if err != nil {
+		return nil, err
+	}
+	metrics.Registrations.Inc()
+	return s.repo.GetUserByID(ctx, newID)
+}
+
+func (s *Service) validateUsername(username string) error {
+	if username == "" || strings.EqualFold(username, systemCounterparty) ||
+		strings.HasPrefix(strings.ToLower(username), domain.DeletedUsernamePrefix) {
+		return ErrInvalidUsername
+	}
+	if s.cfg.UsernamePattern != nil && !s.cfg.UsernamePattern.MatchString(username) {
+		return ErrInvalidUsername
+	}
+	return nil
+}
+
+func (s *Service) validInviteCode(code string) bool {
+	valid := false
+	for _, c := range s.cfg.InviteCodes {
+		if subtle.ConstantTimeCompare([]byte(c), []byte(code)) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
@@ -0,0 +1,42 @@
+package usecase
+
+import (
+	"context"
+	"time"
+)
+
+// expiryNotice is how far ahead /api/info warns about expiring coins.
+const expiryNotice = 30 * 24 * time.Hour
+
+type ExpiringCoins struct {
+	Amount    int       `json:"amount"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// ExpireCoins expires every coin credited more than CoinLifetime ago and not
+// yet spent. It returns the number of coins expired.
+func (s *Service) ExpireCoins(ctx context.Context) (_ int, err error) {
+	ctx, span := tracer.Start(ctx, "Service.ExpireCoins")
+	defer func() { endSpan(span, err) }()
+	if s.cfg.CoinLifetime <= 0 {
+		return 0, nil
+	}
+	return s.repo.ExpireCoins(ctx, time.Now().Add(-s.cfg.CoinLifetime))
+}
+
+// upcomingExpirations lists the user's coins that expire within expiryNotice,
+// soonest first.
+func (s *Service) upcomingExpirations(ctx context.Context, userID int) ([]ExpiringCoins, error) {
+	if s.cfg.CoinLifetime <= 0 {
+		return nil, nil
+	}
+	lots, err := s.repo.ListOpenLots(ctx, userID, time.Now().Add(expiryNotice-s.cfg.CoinLifetime))
+	if err != nil {
+		return nil, err
+	}
+	var res []ExpiringCoins
+	for _, l := range lots {
+		res = append(res, ExpiringCoins{Amount: l.Remaining, ExpiresAt: l.CreatedAt.Add(s.cfg.CoinLifetime)})
+	}
+	return res, nil
+}
@@ -0,0 +1,142 @@
+package usecase
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"merchShop/internal/domain"
+)
+
+const (
+	maxGrantLines  = 1000
+	maxGrantAmount = 100000
+
+	// systemCounterparty is shown instead of a username for granted coins.
+	systemCounterparty = "system"
+)
+
+var (
+	ErrEmptyGrant         = errors.New("at least one grant is required")
+	ErrTooManyGrants      = fmt.Errorf("a single request may contain at most %d grants", maxGrantLines)
+	ErrInvalidGrantAmount = fmt.Errorf("grant amount must be between 1 and %d", maxGrantAmount)
+	ErrReasonRequired     = errors.New("every grant needs a reason")
+	ErrUnknownRecipient   = errors.New("unknown recipient")
+	ErrInvalidGrantCSV    = errors.New("invalid grants CSV")
+)
+
+type GrantRequest struct {
+	Username string `json:"username"`
+	Amount   int    `json:"amount"`
+	Reason   string `json:"reason"`
+}
+
+type GrantResponse struct {
+	Recipients int `json:"recipients"`
+	Total      int `json:"total"`
+}
+
+// GrantCoins mints coins to one or many users on behalf of actorID. The batch
+// is validated up front and applied atomically, so a typo in one row of a
+// CSV does not leave the others half-paid.
+func (s *Service) GrantCoins(ctx context.Context, actorID int, grants []GrantRequest) (_ *GrantResponse, err error) {
+	ctx, span := tracer.Start(ctx, "Service.GrantCoins")
+	defer func() { endSpan(span, err) }()
+	if len(grants) == 0 {
+		return nil, ErrEmptyGrant
+	}
+	if len(grants) > maxGrantLines {
+		return nil, ErrTooManyGrants
+	}
+
+	var (
+		lines   []domain.Grant
+		unknown []string
+		frozen  []string
+		total   int
+	)
+	users := make(map[string]int)
+	for _, g := range grants {
+		if g.Amount <= 0 || g.Amount > maxGrantAmount {
+			return nil, ErrInvalidGrantAmount
+		}
+		reason, err := sanitizeMemo(g.Reason)
+		if err != nil {
+			return nil, err
+		}
+		if reason == "" {
+			return nil, ErrReasonRequired
+		}
+		userID, ok := users[g.Username]
+		if !ok {
+			u, err := s.repo.GetUserByUsername(ctx, g.Username)
+			if err != nil {
+				return nil, err
+			}
+			switch {
+			case u == nil, u.Status == domain.StatusDeleted:
+				unknown = append(unknown, g.Username)
+			case u.Status == domain.StatusFrozen:
+				frozen = append(frozen, g.Username)
+			default:
+				userID = u.ID
+			}
+			users[g.Username] = userID
+		}
+		lines = append(lines, domain.Grant{UserID: userID, Amount: g.Amount, Reason: reason})
+		total += g.Amount
+	}
+	if len(unknown) > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownRecipient, strings.Join(unknown, ", "))
+	}
+	if len(frozen) > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrRecipientFrozen, strings.Join(frozen, ", "))
+	}
+
+	if err := s.repo.GrantCoinsTx(ctx, actorID, lines); err != nil {
+		return nil, err
+	}
+	slog.InfoContext(ctx, "coins granted",
+		slog.Int("actor_id", actorID), slog.Int("recipients", len(lines)), slog.Int("total", total))
+	return &GrantResponse{Recipients: len(lines), Total: total}, nil
+}
+
+// ParseGrantsCSV reads "username,amount[,reason]" rows. A header row starting
+// with "username" is skipped, and rows without a reason get defaultReason.
+func ParseGrantsCSV(r io.Reader, defaultReason string) ([]GrantRequest, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	var grants []GrantRequest
+	for line := 1; ; line++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidGrantCSV, err)
+		}
+		if line == 1 && strings.EqualFold(strings.TrimSpace(rec[0]), "username") {
+			continue
+		}
+		if len(rec) < 2 || len(rec) > 3 {
+			return nil, fmt.Errorf("%w: line %d: expected username,amount[,reason]", ErrInvalidGrantCSV, line)
+		}
+		amount, err := strconv.Atoi(strings.TrimSpace(rec[1]))
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: invalid amount %q", ErrInvalidGrantCSV, line, rec[1])
+		}
+		g := GrantRequest{Username: strings.TrimSpace(rec[0]), Amount: amount, Reason: defaultReason}
+		if len(rec) == 3 && strings.TrimSpace(rec[2]) != "" {
+			g.Reason = strings.TrimSpace(rec[2])
+		}
+		grants = append(grants, g)
+	}
+	return grants, nil
+}
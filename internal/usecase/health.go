@@ -0,0 +1,16 @@
+package usecase
+
+import "context"
+
+// Readiness runs the dependency checks behind /readyz and returns the error
+// of each, nil meaning healthy. The schema is only checked once the database
+// answers.
+func (s *Service) Readiness(ctx context.Context) map[string]error {
+	ctx, span := tracer.Start(ctx, "Service.Readiness")
+	defer span.End()
+	checks := map[string]error{"database": s.repo.Ping(ctx)}
+	if checks["database"] == nil {
+		checks["schema"] = s.repo.CheckSchema(ctx)
+	}
+	return checks
+}
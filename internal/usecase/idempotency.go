@@ -0,0 +1,28 @@
+package usecase
+
+import (
+	"context"
+
+	"merchShop/internal/domain"
+)
+
+// The methods below let the HTTP idempotency middleware persist responses
+// without talking to the repository directly.
+
+func (s *Service) ReserveIdempotencyKey(ctx context.Context, userID int, key, requestHash string) (_ *domain.IdempotencyRecord, _ bool, err error) {
+	ctx, span := tracer.Start(ctx, "Service.ReserveIdempotencyKey")
+	defer func() { endSpan(span, err) }()
+	return s.repo.ReserveIdempotencyKey(ctx, userID, key, requestHash)
+}
+
+func (s *Service) CompleteIdempotencyKey(ctx context.Context, userID int, key string, status int, body []byte) (err error) {
+	ctx, span := tracer.Start(ctx, "Service.CompleteIdempotencyKey")
+	defer func() { endSpan(span, err) }()
+	return s.repo.CompleteIdempotencyKey(ctx, userID, key, status, body)
+}
+
+func (s *Service) ReleaseIdempotencyKey(ctx context.Context, userID int, key string) (err error) {
+	ctx, span := tracer.Start(ctx, "Service.ReleaseIdempotencyKey")
+	defer func() { endSpan(span, err) }()
+	return s.repo.ReleaseIdempotencyKey(ctx, userID, key)
+}
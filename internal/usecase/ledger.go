@@ -0,0 +1,147 @@
+package usecase
+
+import (
+	"context"
+	"time"
+)
+
+type BalanceMismatch struct {
+	UserID   int    `json:"userId"`
+	Username string `json:"username"`
+	Cached   int    `json:"cached"`
+	Ledger   int    `json:"ledger"`
+}
+
+type LedgerReport struct {
+	Consistent        bool              `json:"consistent"`
+	UnbalancedEntries []int             `json:"unbalancedEntries"`
+	Mismatches        []BalanceMismatch `json:"mismatches"`
+}
+
+// VerifyLedger checks that every ledger entry is balanced and that the cached
+// users.coins projection matches the ledger.
+func (s *Service) VerifyLedger(ctx context.Context) (_ *LedgerReport, err error) {
+	ctx, span := tracer.Start(ctx, "Service.VerifyLedger")
+	defer func() { endSpan(span, err) }()
+	unbalanced, err := s.repo.ListUnbalancedEntries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	drift, err := s.repo.ListBalanceDrift(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &LedgerReport{
+		UnbalancedEntries: unbalanced,
+		Mismatches:        make([]BalanceMismatch, 0, len(drift)),
+	}
+	if report.UnbalancedEntries == nil {
+		report.UnbalancedEntries = []int{}
+	}
+	for _, d := range drift {
+		report.Mismatches = append(report.Mismatches, BalanceMismatch{
+			UserID:   d.UserID,
+			Username: d.Username,
+			Cached:   d.Cached,
+			Ledger:   d.Ledger,
+		})
+	}
+	report.Consistent = len(unbalanced) == 0 && len(drift) == 0
+	return report, nil
+}
+
+// RebuildBalances recomputes users.coins from the ledger and returns the
+// number of repaired users.
+func (s *Service) RebuildBalances(ctx context.Context) (_ int, err error) {
+	ctx, span := tracer.Start(ctx, "Service.RebuildBalances")
+	defer func() { endSpan(span, err) }()
+	return s.repo.RebuildBalances(ctx)
+}
+
+type Discrepancy struct {
+	UserID   int    `json:"userId"`
+	Username string `json:"username"`
+	Cached   int    `json:"cached"`
+	Ledger   int    `json:"ledger"`
+	History  int    `json:"history"`
+	// Repaired is set when the cached balance was rewritten from the ledger.
+	Repaired bool `json:"repaired"`
+}
+
+type ReconcileReport struct {
+	GeneratedAt       time.Time     `json:"generatedAt"`
+	UsersChecked      int           `json:"usersChecked"`
+	UnbalancedEntries []int         `json:"unbalancedEntries"`
+	Discrepancies     []Discrepancy `json:"discrepancies"`
+	Repaired          int           `json:"repaired"`
+}
+
+// Consistent reports whether nothing is left to investigate after the run.
+func (r *ReconcileReport) Consistent() bool {
+	if len(r.UnbalancedEntries) > 0 {
+		return false
+	}
+	for _, d := range r.Discrepancies {
+		if !d.Repaired || d.Ledger != d.History {
+			return false
+		}
+	}
+	return true
+}
+
+// Reconcile compares each user's cached balance with the ledger and with the
+// balance recomputed from history. With repair set, cached balances are
+// rewritten from the ledger; ledger/history mismatches are only reported
+// since the ledger is the source of truth and needs a manual correction.
+func (s *Service) Reconcile(ctx context.Context, repair bool) (_ *ReconcileReport, err error) {
+	ctx, span := tracer.Start(ctx, "Service.Reconcile")
+	defer func() { endSpan(span, err) }()
+	unbalanced, err := s.repo.ListUnbalancedEntries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	balances, err := s.repo.ListUserBalances(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ReconcileReport{
+		GeneratedAt:       time.Now().UTC(),
+		UsersChecked:      len(balances),
+		UnbalancedEntries: unbalanced,
+		Discrepancies:     []Discrepancy{},
+	}
+	if report.UnbalancedEntries == nil {
+		report.UnbalancedEntries = []int{}
+	}
+	drifted := 0
+	for _, b := range balances {
+		if b.Cached == b.Ledger && b.Ledger == b.History {
+			continue
+		}
+		if b.Cached != b.Ledger {
+			drifted++
+		}
+		report.Discrepancies = append(report.Discrepancies, Discrepancy{
+			UserID:   b.UserID,
+			Username: b.Username,
+			Cached:   b.Cached,
+			Ledger:   b.Ledger,
+			History:  b.History,
+		})
+	}
+
+	if repair && drifted > 0 {
+		n, err := s.repo.RebuildBalances(ctx)
+		if err != nil {
+			return nil, err
+		}
+		report.Repaired = n
+		for i := range report.Discrepancies {
+			d := &report.Discrepancies[i]
+			d.Repaired = d.Cached != d.Ledger
+		}
+	}
+	return report, nil
+}
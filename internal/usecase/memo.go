@@ -0,0 +1,33 @@
+package usecase
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const maxMemoLength = 200
+
+var ErrMemoTooLong = fmt.Errorf("memo must be at most %d characters", maxMemoLength)
+
+// sanitizeMemo drops control and invisible formatting characters (including
+// bidi overrides and zero-width spaces), collapses whitespace and enforces the
+// length limit.
+func sanitizeMemo(memo string) (string, error) {
+	memo = strings.ToValidUTF8(memo, "")
+	memo = strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsSpace(r):
+			return ' '
+		case unicode.IsControl(r), unicode.Is(unicode.Cf, r):
+			return -1
+		}
+		return r
+	}, memo)
+	memo = strings.Join(strings.Fields(memo), " ")
+	if utf8.RuneCountInString(memo) > maxMemoLength {
+		return "", ErrMemoTooLong
+	}
+	return memo, nil
+}
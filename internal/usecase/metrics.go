@@ -0,0 +1,33 @@
+package usecase
+
+import "merchShop/internal/metrics"
+
+// purchaseFailureReason maps a PlaceOrder error to a bounded label value.
+func purchaseFailureReason(err error) string {
+	switch err {
+	case ErrNotEnoughCoins:
+		return "not_enough_coins"
+	case ErrOutOfStock:
+		return "out_of_stock"
+	case ErrUnknownItem:
+		return "unknown_item"
+	case ErrItemRetired:
+		return "item_retired"
+	case ErrAccountFrozen:
+		return "account_frozen"
+	case ErrEmptyOrder, ErrTooManyLines, ErrInvalidQuantity:
+		return "invalid_order"
+	default:
+		return "error"
+	}
+}
+
+func recordPurchase(resp *OrderResponse, err error) {
+	if err != nil {
+		metrics.PurchaseFailures.WithLabelValues(purchaseFailureReason(err)).Inc()
+		return
+	}
+	for _, it := range resp.Items {
+		metrics.ItemsPurchased.WithLabelValues(it.Item).Add(float64(it.Quantity))
+	}
+}
@@ -0,0 +1,112 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"merchShop/internal/domain"
+)
+
+const (
+	maxOrderLines   = 50
+	maxLineQuantity = 1000
+)
+
+var (
+	ErrEmptyOrder      = errors.New("order must contain at least one item")
+	ErrTooManyLines    = fmt.Errorf("order must contain at most %d different items", maxOrderLines)
+	ErrInvalidQuantity = fmt.Errorf("quantity must be between 1 and %d", maxLineQuantity)
+)
+
+type OrderItem struct {
+	Item     string `json:"item"`
+	Quantity int    `json:"quantity"`
+}
+
+type OrderResponse struct {
+	Items []OrderItem `json:"items"`
+	Total int         `json:"total"`
+}
+
+// PlaceOrder buys every line of the cart or nothing at all. Lines for the
+// same item are merged before the order reaches the repository.
+func (s *Service) PlaceOrder(ctx context.Context, userID int, items []OrderItem) (_ *OrderResponse, err error) {
+	ctx, span := tracer.Start(ctx, "Service.PlaceOrder")
+	defer func() { endSpan(span, err) }()
+	resp, err := s.placeOrder(ctx, userID, items)
+	recordPurchase(resp, err)
+	return resp, err
+}
+
+func (s *Service) placeOrder(ctx context.Context, userID int, items []OrderItem) (*OrderResponse, error) {
+	if len(items) == 0 {
+		return nil, ErrEmptyOrder
+	}
+
+	var lines []domain.OrderLine
+	index := make(map[string]int)
+	for _, it := range items {
+		if it.Quantity <= 0 || it.Quantity > maxLineQuantity {
+			return nil, ErrInvalidQuantity
+		}
+		if i, ok := index[it.Item]; ok {
+			lines[i].Quantity += it.Quantity
+			if lines[i].Quantity > maxLineQuantity {
+				return nil, ErrInvalidQuantity
+			}
+			continue
+		}
+		index[it.Item] = len(lines)
+		lines = append(lines, domain.OrderLine{ItemName: it.Item, Quantity: it.Quantity})
+	}
+	if len(lines) > maxOrderLines {
+		return nil, ErrTooManyLines
+	}
+
+	total := 0
+	for i := range lines {
+		item, err := s.repo.GetMerchItem(ctx, lines[i].ItemName)
+		if err != nil {
+			return nil, err
+		}
+		if item == nil {
+			return nil, ErrUnknownItem
+		}
+		if !item.Active {
+			return nil, ErrItemRetired
+		}
+		if item.Stock < lines[i].Quantity {
+			return nil, ErrOutOfStock
+		}
+		lines[i].UnitPrice = item.Price
+		total += item.Price * lines[i].Quantity
+	}
+
+	user, err := s.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+	if user.Status == domain.StatusFrozen {
+		return nil, ErrAccountFrozen
+	}
+	if user.Coins < total {
+		return nil, ErrNotEnoughCoins
+	}
+
+	charged, err := s.repo.BuyMerchTx(ctx, user.ID, lines)
+	if err != nil {
+		return nil, err
+	}
+	slog.InfoContext(ctx, "order placed", slog.Int("lines", len(lines)), slog.Int("total", charged))
+
+	resp := &OrderResponse{Total: charged}
+	for _, l := range lines {
+		resp.Items = append(resp.Items, OrderItem{Item: l.ItemName, Quantity: l.Quantity})
+	}
+	return resp, nil
+}
@@ -0,0 +1,95 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"merchShop/internal/domain"
+)
+
+var (
+	ErrPurchaseNotFound   = errors.New("purchase not found")
+	ErrRefundWindowClosed = errors.New("refund window has expired")
+	ErrNothingToRefund    = domain.ErrNothingToRefund
+)
+
+type RefundResponse struct {
+	PurchaseID int    `json:"purchaseId"`
+	Item       string `json:"item"`
+	Quantity   int    `json:"quantity"`
+	Amount     int    `json:"amount"`
+}
+
+// RefundPurchase returns quantity units of the user's own purchase within the
+// configured refund window. A zero quantity refunds everything not yet returned.
+func (s *Service) RefundPurchase(ctx context.Context, userID, purchaseID, quantity int) (_ *RefundResponse, err error) {
+	ctx, span := tracer.Start(ctx, "Service.RefundPurchase")
+	defer func() { endSpan(span, err) }()
+	p, err := s.repo.GetPurchase(ctx, purchaseID)
+	if err != nil {
+		return nil, err
+	}
+	if p == nil || p.UserID != userID {
+		return nil, ErrPurchaseNotFound
+	}
+	if time.Since(p.CreatedAt) > s.cfg.RefundWindow {
+		return nil, ErrRefundWindowClosed
+	}
+	return s.refund(ctx, p, quantity, userID, false)
+}
+
+// AdminRefundPurchase refunds any purchase regardless of the refund window.
+// The owner's account must not be frozen.
+func (s *Service) AdminRefundPurchase(ctx context.Context, adminID, purchaseID, quantity int) (_ *RefundResponse, err error) {
+	ctx, span := tracer.Start(ctx, "Service.AdminRefundPurchase")
+	defer func() { endSpan(span, err) }()
+	p, err := s.repo.GetPurchase(ctx, purchaseID)
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return nil, ErrPurchaseNotFound
+	}
+	return s.refund(ctx, p, quantity, adminID, true)
+}
+
+func (s *Service) refund(ctx context.Context, p *domain.Purchase, quantity, actorID int, override bool) (*RefundResponse, error) {
+	remaining := p.Quantity - p.RefundedQuantity
+	if quantity == 0 {
+		quantity = remaining
+	}
+	if quantity < 0 {
+		return nil, ErrInvalidQuantity
+	}
+	if quantity == 0 || quantity > remaining {
+		return nil, ErrNothingToRefund
+	}
+	// A frozen account cannot receive coins, not even through an admin
+	// override; unfreeze it first.
+	owner, err := s.repo.GetUserByID(ctx, p.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if owner != nil && owner.Status == domain.StatusFrozen {
+		return nil, ErrAccountFrozen
+	}
+
+	refund := domain.Refund{
+		PurchaseID:    p.ID,
+		UserID:        p.UserID,
+		Quantity:      quantity,
+		Amount:        p.UnitPrice * quantity,
+		AdminOverride: override,
+		RefundedBy:    actorID,
+	}
+	if _, err := s.repo.RefundPurchaseTx(ctx, refund); err != nil {
+		return nil, err
+	}
+	return &RefundResponse{
+		PurchaseID: p.ID,
+		Item:       p.ItemName,
+		Quantity:   refund.Quantity,
+		Amount:     refund.Amount,
+	}, nil
+}
@@ -3,44 +3,114 @@ package usecase
 import (
 	"context"
 	"errors"
-	"fmt"
+	"log/slog"
 	"regexp"
+	"time"
 
 	"golang.org/x/crypto/bcrypt"
 	"merchShop/internal/domain"
+	"merchShop/internal/metrics"
 )
 
 var (
 	ErrInvalidCredentials = errors.New("invalid username or password")
-	ErrNotEnoughCoins     = errors.New("not enough coins")
+	ErrNotEnoughCoins     = domain.ErrNotEnoughCoins
+	ErrUnknownItem        = domain.ErrUnknownItem
+	ErrItemRetired        = domain.ErrItemRetired
+	ErrItemExists         = errors.New("item already exists")
+	ErrInvalidItemName    = errors.New("item name must be 1-64 characters: lowercase letters, digits and dashes")
+	ErrInvalidPrice       = errors.New("price must be greater than zero")
+	ErrInvalidStock       = errors.New("stock must not be negative")
+	ErrOutOfStock         = domain.ErrOutOfStock
+	ErrAccountFrozen      = domain.ErrAccountFrozen
+	ErrRecipientFrozen    = errors.New("recipient account is frozen")
+	ErrInvalidAmount      = errors.New("amount must be greater than zero")
+	ErrRecipientNotFound  = errors.New("recipient user not found")
+	ErrSelfTransfer       = errors.New("cannot send coins to the same user")
 	ErrWeakPassword       = errors.New("password does not meet security " +
 		"requirements: minimum 8 characters, at least one uppercase letter, one " +
 		"lowercase letter, one digit, and one special character")
 )
 
 type Repository interface {
-	CreateUser(ctx context.Context, username, passwordHash string) (int, error)
+	CreateUser(ctx context.Context, username, passwordHash string, bonus int) (int, error)
 	GetUserByUsername(ctx context.Context, username string) (*domain.User, error)
 	GetUserByID(ctx context.Context, id int) (*domain.User, error)
-	UpdateUserCoins(ctx context.Context, userID int, newCoins int) error
+	SetUserRole(ctx context.Context, userID int, role domain.Role) error
+	SetUserStatus(ctx context.Context, userID int, status domain.UserStatus) error
+	DeleteUserTx(ctx context.Context, userID, actorID int) error
 
-	CreateTransaction(ctx context.Context, fromID, toID, amount int) error
 	ListSentTransactions(ctx context.Context, userID int) ([]domain.CoinTransaction, error)
 	ListReceivedTransactions(ctx context.Context, userID int) ([]domain.CoinTransaction, error)
+	ListTransactions(ctx context.Context, f domain.TransactionFilter) ([]domain.CoinTransaction, error)
 
 	AddItemToUser(ctx context.Context, userID int, itemName string, qty int) error
 	ListUserInventory(ctx context.Context, userID int) ([]domain.UserInventory, error)
+	ListUserPurchases(ctx context.Context, userID int) ([]domain.Purchase, error)
+	GetPurchase(ctx context.Context, id int) (*domain.Purchase, error)
 
-	TransferCoins(ctx context.Context, fromID, toID, amount int) error
-	BuyMerchTx(ctx context.Context, userID int, itemName string, price int) error
+	GetMerchItem(ctx context.Context, name string) (*domain.MerchItem, error)
+	ListMerchItems(ctx context.Context, activeOnly bool) ([]domain.MerchItem, error)
+	CreateMerchItem(ctx context.Context, name string, price, stock int, description string) (int, error)
+	UpdateMerchItemPrice(ctx context.Context, name string, price int) error
+	UpdateMerchItemStock(ctx context.Context, name string, stock int) error
+	SetMerchItemActive(ctx context.Context, name string, active bool) error
+
+	TransferCoins(ctx context.Context, fromID, toID, amount int, memo string) error
+	BuyMerchTx(ctx context.Context, userID int, lines []domain.OrderLine) (int, error)
+	RefundPurchaseTx(ctx context.Context, refund domain.Refund) (int, error)
+	GrantCoinsTx(ctx context.Context, actorID int, grants []domain.Grant) error
+	PayAllowance(ctx context.Context, period string, amount int, memo string) (int, error)
+	ListOpenLots(ctx context.Context, userID int, creditedBefore time.Time) ([]domain.CoinLot, error)
+	ExpireCoins(ctx context.Context, creditedBefore time.Time) (int, error)
+
+	ListBalanceDrift(ctx context.Context) ([]domain.BalanceDrift, error)
+	ListUnbalancedEntries(ctx context.Context) ([]int, error)
+	RebuildBalances(ctx context.Context) (int, error)
+	ListUserBalances(ctx context.Context) ([]domain.UserBalance, error)
+
+	ReserveIdempotencyKey(ctx context.Context, userID int, key, requestHash string) (*domain.IdempotencyRecord, bool, error)
+	CompleteIdempotencyKey(ctx context.Context, userID int, key string, status int, body []byte) error
+	ReleaseIdempotencyKey(ctx context.Context, userID int, key string) error
+
+	CreateRefreshToken(ctx context.Context, t domain.RefreshToken) error
+	GetRefreshToken(ctx context.Context, tokenHash string) (*domain.RefreshToken, error)
+	RotateRefreshToken(ctx context.Context, oldID int, next domain.RefreshToken) error
+	RevokeRefreshTokenFamily(ctx context.Context, familyID string) error
+	RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error
+	IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error)
+
+	Ping(ctx context.Context) error
+	CheckSchema(ctx context.Context) error
+}
+
+// Config holds the business settings of the shop.
+type Config struct {
+	// RefundWindow is how long after a purchase the buyer may return it.
+	RefundWindow time.Duration
+	// RefreshTokenTTL is how long a refresh token stays valid if unused.
+	RefreshTokenTTL time.Duration
+	// InviteCodes, if set, are required to register a new account.
+	InviteCodes []string
+	// UsernamePattern, if set, restricts the usernames that can register.
+	UsernamePattern *regexp.Regexp
+	// SignupBonus is the number of coins issued to every new account.
+	SignupBonus int
+	// AllowanceAmount is credited to every user once per allowance period;
+	// zero disables the allowance.
+	AllowanceAmount int
+	// CoinLifetime is how long credited coins stay spendable; zero disables
+	// expiry.
+	CoinLifetime time.Duration
 }
 
 type Service struct {
 	repo Repository
+	cfg  Config
 }
 
-func NewService(r Repository) *Service {
-	return &Service{repo: r}
+func NewService(r Repository, cfg Config) *Service {
+	return &Service{repo: r, cfg: cfg}
 }
 
 func validatePassword(password string) error {
@@ -63,64 +133,197 @@ func validatePassword(password string) error {
 	return nil
 }
 
-func (s *Service) RegisterOrLogin(ctx context.Context, username, password string) (*domain.User, error) {
+// RegisterOrLogin is the legacy /api/auth flow: unknown usernames are
+// registered on the spot. When invite codes are required it only logs in.
+func (s *Service) RegisterOrLogin(ctx context.Context, username, password string) (_ *domain.User, err error) {
+	ctx, span := tracer.Start(ctx, "Service.RegisterOrLogin")
+	defer func() { endSpan(span, err) }()
 	user, err := s.repo.GetUserByUsername(ctx, username)
 	if err != nil {
 		return nil, err
 	}
 	if user == nil {
-		if err := validatePassword(password); err != nil {
-			return nil, err
+		if len(s.cfg.InviteCodes) > 0 {
+			return nil, ErrRegistrationClosed
 		}
-		hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-		if err != nil {
-			return nil, fmt.Errorf("failed to hash password: %w", err)
-		}
-		newID, err := s.repo.CreateUser(ctx, username, string(hashed))
-		if err != nil {
+		if err := s.validateUsername(username); err != nil {
 			return nil, err
 		}
-		return s.repo.GetUserByID(ctx, newID)
+		return s.createUser(ctx, username, password)
 	}
 
 	if err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
 		return nil, ErrInvalidCredentials
 	}
+	if user.Status == domain.StatusFrozen {
+		return nil, ErrAccountFrozen
+	}
 	return user, nil
 }
 
-func (s *Service) SendCoin(ctx context.Context, fromUserID int, toUsername string, amount int) error {
+func (s *Service) SendCoin(ctx context.Context, fromUserID int, toUsername string, amount int, memo string) (err error) {
+	ctx, span := tracer.Start(ctx, "Service.SendCoin")
+	defer func() { endSpan(span, err) }()
 	if amount <= 0 {
-		return fmt.Errorf("amount must be greater than zero")
+		return ErrInvalidAmount
+	}
+	memo, err = sanitizeMemo(memo)
+	if err != nil {
+		return err
 	}
 	fromUser, err := s.repo.GetUserByID(ctx, fromUserID)
-	if err != nil || fromUser == nil {
-		return fmt.Errorf("sender user not found")
+	if err != nil {
+		return err
+	}
+	if fromUser == nil {
+		return ErrUserNotFound
 	}
 	toUser, err := s.repo.GetUserByUsername(ctx, toUsername)
-	if err != nil || toUser == nil {
-		return fmt.Errorf("recipient user not found")
+	if err != nil {
+		return err
+	}
+	if toUser == nil || toUser.Status == domain.StatusDeleted {
+		return ErrRecipientNotFound
 	}
 	if fromUser.ID == toUser.ID {
-		return fmt.Errorf("cannot send coins to the same user")
+		return ErrSelfTransfer
+	}
+	if fromUser.Status == domain.StatusFrozen {
+		return ErrAccountFrozen
+	}
+	if toUser.Status == domain.StatusFrozen {
+		return ErrRecipientFrozen
 	}
-	return s.repo.TransferCoins(ctx, fromUser.ID, toUser.ID, amount)
+	if err := s.repo.TransferCoins(ctx, fromUser.ID, toUser.ID, amount, memo); err != nil {
+		return err
+	}
+	metrics.CoinsTransferred.Add(float64(amount))
+	slog.InfoContext(ctx, "coins transferred",
+		slog.Int("from_user_id", fromUser.ID), slog.Int("to_user_id", toUser.ID), slog.Int("amount", amount))
+	return nil
 }
 
-func (s *Service) BuyMerch(ctx context.Context, userID int, itemName string) error {
-	if !domain.IsValidMerchItem(itemName) {
-		return fmt.Errorf("unknown item %s", itemName)
+func (s *Service) BuyMerch(ctx context.Context, userID int, itemName string) (err error) {
+	ctx, span := tracer.Start(ctx, "Service.BuyMerch")
+	defer func() { endSpan(span, err) }()
+	_, err = s.PlaceOrder(ctx, userID, []OrderItem{{Item: itemName, Quantity: 1}})
+	return err
+}
+
+type CatalogItem struct {
+	Name        string `json:"name"`
+	Price       int    `json:"price"`
+	Stock       int    `json:"stock"`
+	Description string `json:"description"`
+	Active      bool   `json:"active"`
+}
+
+type ItemsResponse struct {
+	Items []CatalogItem `json:"items"`
+}
+
+func (s *Service) ListItems(ctx context.Context) (_ *ItemsResponse, err error) {
+	ctx, span := tracer.Start(ctx, "Service.ListItems")
+	defer func() { endSpan(span, err) }()
+	return s.listItems(ctx, true)
+}
+
+// ListAllItems returns the whole catalog including retired items.
+func (s *Service) ListAllItems(ctx context.Context) (_ *ItemsResponse, err error) {
+	ctx, span := tracer.Start(ctx, "Service.ListAllItems")
+	defer func() { endSpan(span, err) }()
+	return s.listItems(ctx, false)
+}
+
+func (s *Service) listItems(ctx context.Context, activeOnly bool) (*ItemsResponse, error) {
+	items, err := s.repo.ListMerchItems(ctx, activeOnly)
+	if err != nil {
+		return nil, err
 	}
-	price := domain.GetItemPrice(itemName)
-	user, err := s.repo.GetUserByID(ctx, userID)
-	if err != nil || user == nil {
-		return fmt.Errorf("user not found")
+	resp := &ItemsResponse{Items: make([]CatalogItem, 0, len(items))}
+	for _, it := range items {
+		resp.Items = append(resp.Items, CatalogItem{
+			Name:        it.Name,
+			Price:       it.Price,
+			Stock:       it.Stock,
+			Description: it.Description,
+			Active:      it.Active,
+		})
+	}
+	return resp, nil
+}
+
+var itemNameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,63}$`)
+
+func (s *Service) CreateItem(ctx context.Context, name string, price, stock int, description string) (err error) {
+	ctx, span := tracer.Start(ctx, "Service.CreateItem")
+	defer func() { endSpan(span, err) }()
+	if !itemNameRe.MatchString(name) {
+		return ErrInvalidItemName
 	}
-	if user.Coins < price {
-		return ErrNotEnoughCoins
+	if price <= 0 {
+		return ErrInvalidPrice
 	}
+	if stock < 0 {
+		return ErrInvalidStock
+	}
+	existing, err := s.repo.GetMerchItem(ctx, name)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return ErrItemExists
+	}
+	_, err = s.repo.CreateMerchItem(ctx, name, price, stock, description)
+	return err
+}
 
-	return s.repo.BuyMerchTx(ctx, user.ID, itemName, price)
+func (s *Service) UpdateItemPrice(ctx context.Context, name string, price int) (err error) {
+	ctx, span := tracer.Start(ctx, "Service.UpdateItemPrice")
+	defer func() { endSpan(span, err) }()
+	if price <= 0 {
+		return ErrInvalidPrice
+	}
+	item, err := s.repo.GetMerchItem(ctx, name)
+	if err != nil {
+		return err
+	}
+	if item == nil {
+		return ErrUnknownItem
+	}
+	return s.repo.UpdateMerchItemPrice(ctx, name, price)
+}
+
+// UpdateItemStock sets the absolute number of units left, e.g. after a restock.
+func (s *Service) UpdateItemStock(ctx context.Context, name string, stock int) (err error) {
+	ctx, span := tracer.Start(ctx, "Service.UpdateItemStock")
+	defer func() { endSpan(span, err) }()
+	if stock < 0 {
+		return ErrInvalidStock
+	}
+	item, err := s.repo.GetMerchItem(ctx, name)
+	if err != nil {
+		return err
+	}
+	if item == nil {
+		return ErrUnknownItem
+	}
+	return s.repo.UpdateMerchItemStock(ctx, name, stock)
+}
+
+// RetireItem hides the item from the catalog and blocks new purchases.
+// Inventory rows that already reference the item are left untouched.
+func (s *Service) RetireItem(ctx context.Context, name string) (err error) {
+	ctx, span := tracer.Start(ctx, "Service.RetireItem")
+	defer func() { endSpan(span, err) }()
+	item, err := s.repo.GetMerchItem(ctx, name)
+	if err != nil {
+		return err
+	}
+	if item == nil {
+		return ErrUnknownItem
+	}
+	return s.repo.SetMerchItemActive(ctx, name, false)
 }
 
 type InfoResponse struct {
@@ -133,18 +336,37 @@ type InfoResponse struct {
 		Received []struct {
 			FromUser string `json:"fromUser"`
 			Amount   int    `json:"amount"`
+			Memo     string `json:"memo,omitempty"`
 		} `json:"received"`
 		Sent []struct {
 			ToUser string `json:"toUser"`
 			Amount int    `json:"amount"`
+			Memo   string `json:"memo,omitempty"`
 		} `json:"sent"`
 	} `json:"coinHistory"`
+	PurchaseHistory []PurchaseRecord `json:"purchaseHistory"`
+	ExpiringCoins   []ExpiringCoins  `json:"expiringCoins,omitempty"`
 }
 
-func (s *Service) GetInfo(ctx context.Context, userID int) (*InfoResponse, error) {
+type PurchaseRecord struct {
+	ID               int       `json:"id"`
+	Item             string    `json:"item"`
+	Quantity         int       `json:"quantity"`
+	RefundedQuantity int       `json:"refundedQuantity"`
+	UnitPrice        int       `json:"unitPrice"`
+	Total            int       `json:"total"`
+	PurchasedAt      time.Time `json:"purchasedAt"`
+}
+
+func (s *Service) GetInfo(ctx context.Context, userID int) (_ *InfoResponse, err error) {
+	ctx, span := tracer.Start(ctx, "Service.GetInfo")
+	defer func() { endSpan(span, err) }()
 	user, err := s.repo.GetUserByID(ctx, userID)
-	if err != nil || user == nil {
-		return nil, fmt.Errorf("user not found")
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
 	}
 	inv, err := s.repo.ListUserInventory(ctx, userID)
 	if err != nil {
@@ -158,8 +380,16 @@ func (s *Service) GetInfo(ctx context.Context, userID int) (*InfoResponse, error
 	if err != nil {
 		return nil, err
 	}
+	purchases, err := s.repo.ListUserPurchases(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	expiring, err := s.upcomingExpirations(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
 
-	resp := &InfoResponse{Coins: user.Coins}
+	resp := &InfoResponse{Coins: user.Coins, ExpiringCoins: expiring}
 
 	for _, i := range inv {
 		resp.Inventory = append(resp.Inventory, struct {
@@ -170,31 +400,61 @@ func (s *Service) GetInfo(ctx context.Context, userID int) (*InfoResponse, error
 			Quantity: i.Quantity,
 		})
 	}
+	// The history is not capped, so look each counterparty up only once.
+	names := map[int]string{0: systemCounterparty}
+	nameOf := func(id int) (string, error) {
+		if name, ok := names[id]; ok {
+			return name, nil
+		}
+		u, err := s.repo.GetUserByID(ctx, id)
+		if err != nil {
+			return "", err
+		}
+		names[id] = counterpartyName(u)
+		return names[id], nil
+	}
+
 	for _, tx := range receivedTx {
-		fromUser, err := s.repo.GetUserByID(ctx, tx.FromUserID)
-		if err != nil || fromUser == nil {
-			continue
+		from, err := nameOf(tx.FromUserID)
+		if err != nil {
+			return nil, err
 		}
 		resp.CoinHistory.Received = append(resp.CoinHistory.Received, struct {
 			FromUser string `json:"fromUser"`
 			Amount   int    `json:"amount"`
+			Memo     string `json:"memo,omitempty"`
 		}{
-			FromUser: fromUser.Username,
+			FromUser: from,
 			Amount:   tx.Amount,
+			Memo:     tx.Memo,
 		})
 	}
 
 	for _, tx := range sentTx {
-		toUser, err := s.repo.GetUserByID(ctx, tx.ToUserID)
-		if err != nil || toUser == nil {
-			continue
+		to, err := nameOf(tx.ToUserID)
+		if err != nil {
+			return nil, err
 		}
 		resp.CoinHistory.Sent = append(resp.CoinHistory.Sent, struct {
 			ToUser string `json:"toUser"`
 			Amount int    `json:"amount"`
+			Memo   string `json:"memo,omitempty"`
 		}{
-			ToUser: toUser.Username,
+			ToUser: to,
 			Amount: tx.Amount,
+			Memo:   tx.Memo,
+		})
+	}
+
+	for _, p := range purchases {
+		resp.PurchaseHistory = append(resp.PurchaseHistory, PurchaseRecord{
+			ID:               p.ID,
+			Item:             p.ItemName,
+			Quantity:         p.Quantity,
+			RefundedQuantity: p.RefundedQuantity,
+			UnitPrice:        p.UnitPrice,
+			Total:            p.UnitPrice * p.Quantity,
+			PurchasedAt:      p.CreatedAt,
 		})
 	}
 	return resp, nil
@@ -3,10 +3,17 @@ package usecase
 import (
 	"context"
 	"errors"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"merchShop/internal/domain"
+	"merchShop/internal/metrics"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -15,25 +22,67 @@ type mockRepo struct {
 	usersByName  map[string]*domain.User
 	inventory    []domain.UserInventory
 	transactions []domain.CoinTransaction
+	items        map[string]*domain.MerchItem
+	purchases    []domain.Purchase
+	refunds      []domain.Refund
+	drift        []domain.BalanceDrift
+	balances     []domain.UserBalance
+	payouts      map[string]bool
+	lots         []domain.CoinLot
+	expiredUpTo  time.Time
+	refresh      []domain.RefreshToken
+	revoked      map[string]time.Time
 	lastUserID   int
+	pingErr      error
+	schemaErr    error
+	// missingAccounts counts users without a ledger account.
+	missingAccounts int
+}
+
+var testConfig = Config{
+	RefundWindow:    14 * 24 * time.Hour,
+	RefreshTokenTTL: 30 * 24 * time.Hour,
+	SignupBonus:     1000,
+}
+
+var testCatalog = map[string]int{
+	"t-shirt":    80,
+	"cup":        20,
+	"book":       50,
+	"pen":        10,
+	"powerbank":  200,
+	"hoody":      300,
+	"umbrella":   200,
+	"socks":      10,
+	"wallet":     50,
+	"pink-hoody": 500,
 }
 
 func newMockRepo() *mockRepo {
-	return &mockRepo{
+	m := &mockRepo{
 		users:        make(map[int]*domain.User),
 		usersByName:  make(map[string]*domain.User),
 		inventory:    []domain.UserInventory{},
 		transactions: []domain.CoinTransaction{},
+		items:        make(map[string]*domain.MerchItem),
+		revoked:      make(map[string]time.Time),
+		payouts:      make(map[string]bool),
 	}
+	for name, price := range testCatalog {
+		m.items[name] = &domain.MerchItem{ID: len(m.items) + 1, Name: name, Price: price, Stock: 100, Active: true}
+	}
+	return m
 }
 
-func (m *mockRepo) CreateUser(ctx context.Context, username, passwordHash string) (int, error) {
+func (m *mockRepo) CreateUser(ctx context.Context, username, passwordHash string, bonus int) (int, error) {
 	m.lastUserID++
 	newUser := &domain.User{
 		ID:           m.lastUserID,
 		Username:     username,
 		PasswordHash: passwordHash,
-		Coins:        1000,
+		Coins:        bonus,
+		Role:         domain.RoleEmployee,
+		Status:       domain.StatusActive,
 	}
 	m.users[newUser.ID] = newUser
 	m.usersByName[newUser.Username] = newUser
@@ -54,22 +103,24 @@ func (m *mockRepo) GetUserByID(ctx context.Context, id int) (*domain.User, error
 	return nil, nil
 }
 
-func (m *mockRepo) UpdateUserCoins(ctx context.Context, userID int, newCoins int) error {
-	user, ok := m.users[userID]
-	if !ok {
-		return errors.New("user not found")
-	}
-	user.Coins = newCoins
+func (m *mockRepo) SetUserRole(ctx context.Context, userID int, role domain.Role) error {
+	m.users[userID].Role = role
 	return nil
 }
 
-func (m *mockRepo) CreateTransaction(ctx context.Context, fromID, toID, amount int) error {
-	m.transactions = append(m.transactions, domain.CoinTransaction{
-		ID:         len(m.transactions) + 1,
-		FromUserID: fromID,
-		ToUserID:   toID,
-		Amount:     amount,
-	})
+func (m *mockRepo) SetUserStatus(ctx context.Context, userID int, status domain.UserStatus) error {
+	m.users[userID].Status = status
+	return nil
+}
+
+func (m *mockRepo) DeleteUserTx(ctx context.Context, userID, actorID int) error {
+	u := m.users[userID]
+	delete(m.usersByName, u.Username)
+	u.Coins = 0
+	u.Username = domain.DeletedUsernamePrefix + strconv.Itoa(userID)
+	u.PasswordHash = ""
+	u.Status = domain.StatusDeleted
+	m.usersByName[u.Username] = u
 	return nil
 }
 
@@ -93,6 +144,26 @@ func (m *mockRepo) ListReceivedTransactions(ctx context.Context, userID int) ([]
 	return result, nil
 }
 
+func (m *mockRepo) ListTransactions(ctx context.Context, f domain.TransactionFilter) ([]domain.CoinTransaction, error) {
+	var result []domain.CoinTransaction
+	for i := len(m.transactions) - 1; i >= 0 && len(result) < f.Limit; i-- {
+		t := m.transactions[i]
+		sent := t.FromUserID == f.UserID && (f.CounterpartyID == 0 || t.ToUserID == f.CounterpartyID)
+		received := t.ToUserID == f.UserID && (f.CounterpartyID == 0 || t.FromUserID == f.CounterpartyID)
+		switch {
+		case f.Direction == domain.DirectionSent && !sent,
+			f.Direction == domain.DirectionReceived && !received,
+			!sent && !received,
+			f.BeforeID != 0 && t.ID >= f.BeforeID,
+			!f.From.IsZero() && t.CreatedAt.Before(f.From),
+			!f.To.IsZero() && !t.CreatedAt.Before(f.To):
+			continue
+		}
+		result = append(result, t)
+	}
+	return result, nil
+}
+
 func (m *mockRepo) AddItemToUser(ctx context.Context, userID int, itemName string, qty int) error {
 	found := false
 	for i, inv := range m.inventory {
@@ -116,14 +187,94 @@ func (m *mockRepo) AddItemToUser(ctx context.Context, userID int, itemName strin
 func (m *mockRepo) ListUserInventory(ctx context.Context, userID int) ([]domain.UserInventory, error) {
 	var result []domain.UserInventory
 	for _, inv := range m.inventory {
-		if inv.UserID == userID {
+		if inv.UserID == userID && inv.Quantity > 0 {
 			result = append(result, inv)
 		}
 	}
 	return result, nil
 }
 
-func (m *mockRepo) TransferCoins(ctx context.Context, fromID, toID, amount int) error {
+func (m *mockRepo) GetMerchItem(ctx context.Context, name string) (*domain.MerchItem, error) {
+	if it, ok := m.items[name]; ok {
+		return it, nil
+	}
+	return nil, nil
+}
+
+func (m *mockRepo) ListMerchItems(ctx context.Context, activeOnly bool) ([]domain.MerchItem, error) {
+	var result []domain.MerchItem
+	for _, it := range m.items {
+		if activeOnly && !it.Active {
+			continue
+		}
+		result = append(result, *it)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result, nil
+}
+
+func (m *mockRepo) CreateMerchItem(ctx context.Context, name string, price, stock int, description string) (int, error) {
+	it := &domain.MerchItem{ID: len(m.items) + 1, Name: name, Price: price, Stock: stock, Description: description, Active: true}
+	m.items[name] = it
+	return it.ID, nil
+}
+
+func (m *mockRepo) UpdateMerchItemPrice(ctx context.Context, name string, price int) error {
+	it, ok := m.items[name]
+	if !ok {
+		return errors.New("item not found")
+	}
+	it.Price = price
+	return nil
+}
+
+func (m *mockRepo) UpdateMerchItemStock(ctx context.Context, name string, stock int) error {
+	it, ok := m.items[name]
+	if !ok {
+		return errors.New("item not found")
+	}
+	it.Stock = stock
+	return nil
+}
+
+func (m *mockRepo) SetMerchItemActive(ctx context.Context, name string, active bool) error {
+	it, ok := m.items[name]
+	if !ok {
+		return errors.New("item not found")
+	}
+	it.Active = active
+	return nil
+}
+
+func (m *mockRepo) GetPurchase(ctx context.Context, id int) (*domain.Purchase, error) {
+	for _, p := range m.purchases {
+		if p.ID == id {
+			return &p, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *mockRepo) RefundPurchaseTx(ctx context.Context, refund domain.Refund) (int, error) {
+	for i := range m.purchases {
+		p := &m.purchases[i]
+		if p.ID != refund.PurchaseID {
+			continue
+		}
+		if p.Quantity-p.RefundedQuantity < refund.Quantity {
+			return 0, domain.ErrNothingToRefund
+		}
+		p.RefundedQuantity += refund.Quantity
+		_ = m.AddItemToUser(ctx, refund.UserID, p.ItemName, -refund.Quantity)
+		m.items[p.ItemName].Stock += refund.Quantity
+		m.users[refund.UserID].Coins += refund.Amount
+		m.refunds = append(m.refunds, refund)
+		return len(m.refunds), nil
+	}
+	return 0, domain.ErrNothingToRefund
+}
+
+func (m *mockRepo) TransferCoins(ctx context.Context, fromID, toID, amount int, memo string) error {
 	fromUser, ok := m.users[fromID]
 	if !ok {
 		return errors.New("sender not found")
@@ -145,42 +296,206 @@ func (m *mockRepo) TransferCoins(ctx context.Context, fromID, toID, amount int)
 		FromUserID: fromID,
 		ToUserID:   toID,
 		Amount:     amount,
+		Memo:       memo,
+		CreatedAt:  time.Now(),
 	})
 	return nil
 }
 
-func (m *mockRepo) BuyMerchTx(ctx context.Context, userID int, itemName string, price int) error {
+func (m *mockRepo) BuyMerchTx(ctx context.Context, userID int, lines []domain.OrderLine) (int, error) {
 	user, ok := m.users[userID]
 	if !ok {
-		return errors.New("user not found")
-	}
-	if user.Coins < price {
-		return errors.New("insufficient funds")
+		return 0, errors.New("user not found")
 	}
-	user.Coins -= price
-	found := false
-	for i, inv := range m.inventory {
-		if inv.UserID == userID && inv.ItemName == itemName {
-			m.inventory[i].Quantity++
-			found = true
-			break
+	total := 0
+	for _, l := range lines {
+		item, ok := m.items[l.ItemName]
+		switch {
+		case !ok:
+			return 0, domain.ErrUnknownItem
+		case !item.Active:
+			return 0, domain.ErrItemRetired
+		case item.Stock < l.Quantity:
+			return 0, domain.ErrOutOfStock
 		}
+		total += item.Price * l.Quantity
 	}
-	if !found {
-		m.inventory = append(m.inventory, domain.UserInventory{
-			ID:       len(m.inventory) + 1,
-			UserID:   userID,
-			ItemName: itemName,
-			Quantity: 1,
+	if user.Coins < total {
+		return 0, domain.ErrNotEnoughCoins
+	}
+	user.Coins -= total
+	for _, l := range lines {
+		item := m.items[l.ItemName]
+		item.Stock -= l.Quantity
+		_ = m.AddItemToUser(ctx, userID, l.ItemName, l.Quantity)
+		m.purchases = append(m.purchases, domain.Purchase{
+			ID:        len(m.purchases) + 1,
+			UserID:    userID,
+			ItemName:  l.ItemName,
+			Quantity:  l.Quantity,
+			UnitPrice: item.Price,
+			CreatedAt: time.Now(),
+		})
+	}
+	return total, nil
+}
+
+func (m *mockRepo) GrantCoinsTx(ctx context.Context, actorID int, grants []domain.Grant) error {
+	for _, g := range grants {
+		m.users[g.UserID].Coins += g.Amount
+		m.transactions = append(m.transactions, domain.CoinTransaction{
+			ID:        len(m.transactions) + 1,
+			ToUserID:  g.UserID,
+			Amount:    g.Amount,
+			Memo:      g.Reason,
+			CreatedAt: time.Now(),
 		})
 	}
 	return nil
 }
 
+func (m *mockRepo) PayAllowance(ctx context.Context, period string, amount int, memo string) (int, error) {
+	paid := 0
+	for id := 1; id <= m.lastUserID; id++ {
+		key := period + "/" + strconv.Itoa(id)
+		if m.payouts[key] {
+			continue
+		}
+		m.payouts[key] = true
+		_ = m.GrantCoinsTx(ctx, 0, []domain.Grant{{UserID: id, Amount: amount, Reason: memo}})
+		paid++
+	}
+	return paid, nil
+}
+
+func (m *mockRepo) ListOpenLots(ctx context.Context, userID int, creditedBefore time.Time) ([]domain.CoinLot, error) {
+	var result []domain.CoinLot
+	for _, l := range m.lots {
+		if l.UserID == userID && l.Remaining > 0 && l.CreatedAt.Before(creditedBefore) {
+			result = append(result, l)
+		}
+	}
+	return result, nil
+}
+
+func (m *mockRepo) ExpireCoins(ctx context.Context, creditedBefore time.Time) (int, error) {
+	m.expiredUpTo = creditedBefore
+	total := 0
+	for i, l := range m.lots {
+		if l.Remaining > 0 && l.CreatedAt.Before(creditedBefore) {
+			m.users[l.UserID].Coins -= l.Remaining
+			total += l.Remaining
+			m.lots[i].Remaining = 0
+		}
+	}
+	return total, nil
+}
+
+func (m *mockRepo) ListUserPurchases(ctx context.Context, userID int) ([]domain.Purchase, error) {
+	var result []domain.Purchase
+	for i := len(m.purchases) - 1; i >= 0; i-- {
+		if m.purchases[i].UserID == userID {
+			result = append(result, m.purchases[i])
+		}
+	}
+	return result, nil
+}
+
+func (m *mockRepo) ListBalanceDrift(ctx context.Context) ([]domain.BalanceDrift, error) {
+	return m.drift, nil
+}
+
+func (m *mockRepo) ListUnbalancedEntries(ctx context.Context) ([]int, error) {
+	return nil, nil
+}
+
+func (m *mockRepo) RebuildBalances(ctx context.Context) (int, error) {
+	if m.missingAccounts > 0 {
+		return 0, domain.ErrMissingLedgerAccount
+	}
+	for _, d := range m.drift {
+		m.users[d.UserID].Coins = d.Ledger
+	}
+	n := len(m.drift)
+	m.drift = nil
+	return n, nil
+}
+
+func (m *mockRepo) ListUserBalances(ctx context.Context) ([]domain.UserBalance, error) {
+	return m.balances, nil
+}
+
+func (m *mockRepo) ReserveIdempotencyKey(ctx context.Context, userID int, key, requestHash string) (*domain.IdempotencyRecord, bool, error) {
+	return nil, true, nil
+}
+
+func (m *mockRepo) CompleteIdempotencyKey(ctx context.Context, userID int, key string, status int, body []byte) error {
+	return nil
+}
+
+func (m *mockRepo) ReleaseIdempotencyKey(ctx context.Context, userID int, key string) error {
+	return nil
+}
+
+func (m *mockRepo) CreateRefreshToken(ctx context.Context, t domain.RefreshToken) error {
+	t.ID = len(m.refresh) + 1
+	t.CreatedAt = time.Now()
+	m.refresh = append(m.refresh, t)
+	return nil
+}
+
+func (m *mockRepo) GetRefreshToken(ctx context.Context, tokenHash string) (*domain.RefreshToken, error) {
+	for i := range m.refresh {
+		if m.refresh[i].TokenHash == tokenHash {
+			t := m.refresh[i]
+			return &t, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *mockRepo) RotateRefreshToken(ctx context.Context, oldID int, next domain.RefreshToken) error {
+	old := &m.refresh[oldID-1]
+	if old.RevokedAt != nil {
+		return domain.ErrRefreshTokenReused
+	}
+	now := time.Now()
+	old.RevokedAt = &now
+	return m.CreateRefreshToken(ctx, next)
+}
+
+func (m *mockRepo) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	now := time.Now()
+	for i := range m.refresh {
+		if m.refresh[i].FamilyID == familyID && m.refresh[i].RevokedAt == nil {
+			m.refresh[i].RevokedAt = &now
+		}
+	}
+	return nil
+}
+
+func (m *mockRepo) RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	m.revoked[jti] = expiresAt
+	return nil
+}
+
+func (m *mockRepo) IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	_, ok := m.revoked[jti]
+	return ok, nil
+}
+
+func (m *mockRepo) Ping(ctx context.Context) error {
+	return m.pingErr
+}
+
+func (m *mockRepo) CheckSchema(ctx context.Context) error {
+	return m.schemaErr
+}
+
 func TestService_RegisterOrLogin(t *testing.T) {
 	ctx := context.Background()
 	mock := newMockRepo()
-	svc := NewService(mock)
+	svc := NewService(mock, testConfig)
 
 	u, err := svc.RegisterOrLogin(ctx, "Ziyo", "Strong@Pass123")
 	assert.NoError(t, err)
@@ -204,15 +519,62 @@ func TestService_RegisterOrLogin(t *testing.T) {
 	assert.Equal(t, u2.ID, u2Again.ID, "must match ID")
 }
 
+func TestService_RegisterAndLogin(t *testing.T) {
+	ctx := context.Background()
+	mock := newMockRepo()
+	svc := NewService(mock, testConfig)
+
+	_, err := svc.Login(ctx, "Ziyo", "Strong@Pass123")
+	assert.Equal(t, ErrInvalidCredentials, err, "login must not create accounts")
+	assert.Empty(t, mock.users)
+
+	u, err := svc.Register(ctx, "Ziyo", "Strong@Pass123", "")
+	assert.NoError(t, err)
+	assert.Equal(t, 1000, u.Coins)
+
+	_, err = svc.Register(ctx, "Ziyo", "Strong@Pass123", "")
+	assert.Equal(t, ErrUserExists, err)
+	_, err = svc.Register(ctx, "", "Strong@Pass123", "")
+	assert.Equal(t, ErrInvalidUsername, err)
+
+	u2, err := svc.Login(ctx, "Ziyo", "Strong@Pass123")
+	assert.NoError(t, err)
+	assert.Equal(t, u.ID, u2.ID)
+	_, err = svc.Login(ctx, "Ziyo", "Wrong@Pass123")
+	assert.Equal(t, ErrInvalidCredentials, err)
+}
+
+func TestService_Register_Restrictions(t *testing.T) {
+	ctx := context.Background()
+	mock := newMockRepo()
+	cfg := testConfig
+	cfg.InviteCodes = []string{"hackathon-2024"}
+	cfg.UsernamePattern = regexp.MustCompile(`^[a-z]+\.[a-z]+$`)
+	svc := NewService(mock, cfg)
+
+	_, err := svc.Register(ctx, "ziyo.k", "Strong@Pass123", "wrong")
+	assert.Equal(t, ErrInvalidInviteCode, err)
+	_, err = svc.Register(ctx, "Ziyo", "Strong@Pass123", "hackathon-2024")
+	assert.Equal(t, ErrInvalidUsername, err)
+	_, err = svc.Register(ctx, "ziyo.k", "Strong@Pass123", "hackathon-2024")
+	assert.NoError(t, err)
+
+	// The legacy flow cannot bypass the invite requirement.
+	_, err = svc.RegisterOrLogin(ctx, "ali.b", "Strong@Pass123")
+	assert.Equal(t, ErrRegistrationClosed, err)
+	_, err = svc.RegisterOrLogin(ctx, "ziyo.k", "Strong@Pass123")
+	assert.NoError(t, err)
+}
+
 func TestService_SendCoin(t *testing.T) {
 	ctx := context.Background()
 	mock := newMockRepo()
-	svc := NewService(mock)
+	svc := NewService(mock, testConfig)
 
 	ziyo, _ := svc.RegisterOrLogin(ctx, "Ziyo", "Strong@Pass123")
 	ali, _ := svc.RegisterOrLogin(ctx, "Ali", "Strong@Pass123")
 
-	err := svc.SendCoin(ctx, ziyo.ID, "Ali", 200)
+	err := svc.SendCoin(ctx, ziyo.ID, "Ali", 200, "")
 	assert.NoError(t, err)
 
 	ziyoUpdated, _ := mock.GetUserByID(ctx, ziyo.ID)
@@ -221,17 +583,17 @@ func TestService_SendCoin(t *testing.T) {
 	assert.Equal(t, 800, ziyoUpdated.Coins, "Ziyo = 1000 - 200")
 	assert.Equal(t, 1200, aliUpdated.Coins, "Ali = 1000 + 200")
 
-	err = svc.SendCoin(ctx, ziyo.ID, "Ali", 900)
+	err = svc.SendCoin(ctx, ziyo.ID, "Ali", 900, "")
 	assert.Error(t, err, "insufficient funds expected")
 
-	err = svc.SendCoin(ctx, ziyo.ID, "Ziyo", 100)
+	err = svc.SendCoin(ctx, ziyo.ID, "Ziyo", 100, "")
 	assert.Error(t, err, "you can't send to yourself")
 }
 
 func TestService_BuyMerch(t *testing.T) {
 	ctx := context.Background()
 	mock := newMockRepo()
-	svc := NewService(mock)
+	svc := NewService(mock, testConfig)
 
 	user, _ := svc.RegisterOrLogin(ctx, "TestUser", "Valid@Pass123")
 	err := svc.BuyMerch(ctx, user.ID, "book")
@@ -241,7 +603,7 @@ func TestService_BuyMerch(t *testing.T) {
 	assert.Equal(t, 950, userAfter.Coins)
 
 	err = svc.BuyMerch(ctx, user.ID, "someUnknownItem")
-	assert.Error(t, err)
+	assert.Equal(t, ErrUnknownItem, err)
 
 	err = svc.BuyMerch(ctx, user.ID, "pink-hoody")
 	assert.NoError(t, err)
@@ -255,7 +617,7 @@ func TestService_BuyMerch(t *testing.T) {
 func TestService_GetInfo(t *testing.T) {
 	ctx := context.Background()
 	mock := newMockRepo()
-	svc := NewService(mock)
+	svc := NewService(mock, testConfig)
 
 	ziyo, _ := svc.RegisterOrLogin(ctx, "Ziyo", "Valid@Pass123")
 	ali, _ := svc.RegisterOrLogin(ctx, "Ali", "Valid@Pass123")
@@ -263,17 +625,658 @@ func TestService_GetInfo(t *testing.T) {
 	_ = svc.BuyMerch(ctx, ziyo.ID, "book")
 	_ = svc.BuyMerch(ctx, ziyo.ID, "socks")
 
-	_ = svc.SendCoin(ctx, ziyo.ID, "Ali", 100)
+	_ = svc.SendCoin(ctx, ziyo.ID, "Ali", 100, "")
 
 	respZiyo, err := svc.GetInfo(ctx, ziyo.ID)
 	assert.NoError(t, err)
 	assert.Equal(t, 840, respZiyo.Coins)
 	assert.Len(t, respZiyo.Inventory, 2) // book, socks
+	assert.Len(t, respZiyo.PurchaseHistory, 2)
+	assert.Equal(t, "socks", respZiyo.PurchaseHistory[0].Item, "newest purchase first")
+	assert.Equal(t, 50, respZiyo.PurchaseHistory[1].UnitPrice)
 
 	respAli, err := svc.GetInfo(ctx, ali.ID)
 	assert.NoError(t, err)
 	assert.Equal(t, 1100, respAli.Coins)
 	assert.Len(t, respAli.Inventory, 0)
+	assert.Len(t, respAli.PurchaseHistory, 0)
 	assert.Len(t, respAli.CoinHistory.Received, 1, "one incoming tx from Ziyo")
 	assert.Len(t, respAli.CoinHistory.Sent, 0)
 }
+
+func TestService_GetInfo_FullHistory(t *testing.T) {
+	ctx := context.Background()
+	mock := newMockRepo()
+	svc := NewService(mock, testConfig)
+
+	ziyo, _ := svc.RegisterOrLogin(ctx, "Ziyo", "Valid@Pass123")
+	ali, _ := svc.RegisterOrLogin(ctx, "Ali", "Valid@Pass123")
+	for i := 0; i < 150; i++ {
+		assert.NoError(t, svc.SendCoin(ctx, ziyo.ID, "Ali", 1, ""))
+	}
+
+	respZiyo, err := svc.GetInfo(ctx, ziyo.ID)
+	assert.NoError(t, err)
+	assert.Len(t, respZiyo.CoinHistory.Sent, 150, "/api/info must not truncate the history")
+	assert.Equal(t, "Ali", respZiyo.CoinHistory.Sent[149].ToUser)
+
+	respAli, err := svc.GetInfo(ctx, ali.ID)
+	assert.NoError(t, err)
+	assert.Len(t, respAli.CoinHistory.Received, 150)
+	assert.Equal(t, "Ziyo", respAli.CoinHistory.Received[0].FromUser)
+}
+
+func TestService_ListItems(t *testing.T) {
+	ctx := context.Background()
+	mock := newMockRepo()
+	svc := NewService(mock, testConfig)
+
+	mock.items["pen"].Active = false
+
+	resp, err := svc.ListItems(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, resp.Items, len(testCatalog)-1)
+	for _, it := range resp.Items {
+		assert.NotEqual(t, "pen", it.Name, "inactive items must not be listed")
+		assert.Equal(t, testCatalog[it.Name], it.Price)
+	}
+
+	user, _ := svc.RegisterOrLogin(ctx, "TestUser", "Valid@Pass123")
+	err = svc.BuyMerch(ctx, user.ID, "pen")
+	assert.Equal(t, ErrItemRetired, err)
+}
+
+func TestService_CatalogAdmin(t *testing.T) {
+	ctx := context.Background()
+	mock := newMockRepo()
+	svc := NewService(mock, testConfig)
+
+	assert.Equal(t, ErrInvalidItemName, svc.CreateItem(ctx, "Bad Name", 10, 1, ""))
+	assert.Equal(t, ErrInvalidPrice, svc.CreateItem(ctx, "sticker", 0, 1, ""))
+	assert.Equal(t, ErrInvalidStock, svc.CreateItem(ctx, "sticker", 5, -1, ""))
+	assert.Equal(t, ErrItemExists, svc.CreateItem(ctx, "book", 10, 1, ""))
+	assert.NoError(t, svc.CreateItem(ctx, "sticker", 5, 10, "laptop sticker"))
+
+	user, _ := svc.RegisterOrLogin(ctx, "TestUser", "Valid@Pass123")
+	assert.NoError(t, svc.BuyMerch(ctx, user.ID, "sticker"))
+
+	assert.Equal(t, ErrUnknownItem, svc.UpdateItemPrice(ctx, "nothing", 10))
+	assert.NoError(t, svc.UpdateItemPrice(ctx, "sticker", 15))
+	assert.NoError(t, svc.BuyMerch(ctx, user.ID, "sticker"))
+	userAfter, _ := mock.GetUserByID(ctx, user.ID)
+	assert.Equal(t, 980, userAfter.Coins, "1000 - 5 - 15")
+
+	assert.NoError(t, svc.RetireItem(ctx, "sticker"))
+	assert.Equal(t, ErrItemRetired, svc.BuyMerch(ctx, user.ID, "sticker"))
+
+	info, err := svc.GetInfo(ctx, user.ID)
+	assert.NoError(t, err)
+	assert.Len(t, info.Inventory, 1, "retired item stays in inventory")
+
+	all, err := svc.ListAllItems(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, all.Items, len(testCatalog)+1)
+}
+
+func TestService_BuyMerch_OutOfStock(t *testing.T) {
+	ctx := context.Background()
+	mock := newMockRepo()
+	svc := NewService(mock, testConfig)
+
+	user, _ := svc.RegisterOrLogin(ctx, "TestUser", "Valid@Pass123")
+	assert.NoError(t, svc.UpdateItemStock(ctx, "cup", 1))
+
+	assert.NoError(t, svc.BuyMerch(ctx, user.ID, "cup"))
+	assert.Equal(t, 0, mock.items["cup"].Stock)
+
+	err := svc.BuyMerch(ctx, user.ID, "cup")
+	assert.Equal(t, ErrOutOfStock, err)
+	userAfter, _ := mock.GetUserByID(ctx, user.ID)
+	assert.Equal(t, 980, userAfter.Coins, "failed purchase must not charge")
+
+	assert.Equal(t, ErrInvalidStock, svc.UpdateItemStock(ctx, "cup", -5))
+}
+
+func TestService_PlaceOrder(t *testing.T) {
+	ctx := context.Background()
+	mock := newMockRepo()
+	svc := NewService(mock, testConfig)
+
+	user, _ := svc.RegisterOrLogin(ctx, "TestUser", "Valid@Pass123")
+
+	_, err := svc.PlaceOrder(ctx, user.ID, nil)
+	assert.Equal(t, ErrEmptyOrder, err)
+	_, err = svc.PlaceOrder(ctx, user.ID, []OrderItem{{Item: "pen", Quantity: 0}})
+	assert.Equal(t, ErrInvalidQuantity, err)
+
+	resp, err := svc.PlaceOrder(ctx, user.ID, []OrderItem{
+		{Item: "pen", Quantity: 3},
+		{Item: "cup", Quantity: 2},
+		{Item: "pen", Quantity: 1},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 80, resp.Total, "4*10 + 2*20")
+	assert.Len(t, resp.Items, 2, "duplicate lines are merged")
+
+	info, _ := svc.GetInfo(ctx, user.ID)
+	assert.Equal(t, 920, info.Coins)
+	assert.Equal(t, 96, mock.items["pen"].Stock)
+
+	// The second line cannot be afforded, so nothing must be bought.
+	_, err = svc.PlaceOrder(ctx, user.ID, []OrderItem{
+		{Item: "book", Quantity: 1},
+		{Item: "pink-hoody", Quantity: 2},
+	})
+	assert.Equal(t, ErrNotEnoughCoins, err)
+	_, err = svc.PlaceOrder(ctx, user.ID, []OrderItem{
+		{Item: "book", Quantity: 1},
+		{Item: "nothing", Quantity: 1},
+	})
+	assert.Equal(t, ErrUnknownItem, err)
+
+	infoAfter, _ := svc.GetInfo(ctx, user.ID)
+	assert.Equal(t, 920, infoAfter.Coins)
+	assert.Len(t, infoAfter.Inventory, 2)
+	assert.Equal(t, 100, mock.items["book"].Stock)
+}
+
+func TestService_RefundPurchase(t *testing.T) {
+	ctx := context.Background()
+	mock := newMockRepo()
+	svc := NewService(mock, testConfig)
+
+	ziyo, _ := svc.RegisterOrLogin(ctx, "Ziyo", "Valid@Pass123")
+	ali, _ := svc.RegisterOrLogin(ctx, "Ali", "Valid@Pass123")
+
+	_, err := svc.PlaceOrder(ctx, ziyo.ID, []OrderItem{{Item: "hoody", Quantity: 2}})
+	assert.NoError(t, err)
+	purchaseID := mock.purchases[0].ID
+
+	_, err = svc.RefundPurchase(ctx, ali.ID, purchaseID, 1)
+	assert.Equal(t, ErrPurchaseNotFound, err, "only the buyer may refund")
+
+	resp, err := svc.RefundPurchase(ctx, ziyo.ID, purchaseID, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 300, resp.Amount)
+
+	info, _ := svc.GetInfo(ctx, ziyo.ID)
+	assert.Equal(t, 700, info.Coins, "1000 - 600 + 300")
+	assert.Equal(t, 1, info.Inventory[0].Quantity)
+	assert.Equal(t, 1, info.PurchaseHistory[0].RefundedQuantity)
+	assert.Equal(t, 99, mock.items["hoody"].Stock)
+
+	_, err = svc.RefundPurchase(ctx, ziyo.ID, purchaseID, 2)
+	assert.Equal(t, ErrNothingToRefund, err)
+
+	mock.purchases[0].CreatedAt = time.Now().Add(-testConfig.RefundWindow - time.Hour)
+	_, err = svc.RefundPurchase(ctx, ziyo.ID, purchaseID, 0)
+	assert.Equal(t, ErrRefundWindowClosed, err)
+
+	resp, err = svc.AdminRefundPurchase(ctx, ali.ID, purchaseID, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, resp.Quantity, "zero quantity refunds the rest")
+	assert.True(t, mock.refunds[1].AdminOverride)
+	assert.Equal(t, ali.ID, mock.refunds[1].RefundedBy)
+
+	info, _ = svc.GetInfo(ctx, ziyo.ID)
+	assert.Equal(t, 1000, info.Coins)
+}
+
+func TestService_VerifyLedger(t *testing.T) {
+	ctx := context.Background()
+	mock := newMockRepo()
+	svc := NewService(mock, testConfig)
+
+	report, err := svc.VerifyLedger(ctx)
+	assert.NoError(t, err)
+	assert.True(t, report.Consistent)
+
+	user, _ := svc.RegisterOrLogin(ctx, "Ziyo", "Valid@Pass123")
+	mock.users[user.ID].Coins = 5000
+	mock.drift = []domain.BalanceDrift{{UserID: user.ID, Username: "Ziyo", Cached: 5000, Ledger: 1000}}
+
+	report, err = svc.VerifyLedger(ctx)
+	assert.NoError(t, err)
+	assert.False(t, report.Consistent)
+	assert.Len(t, report.Mismatches, 1)
+
+	repaired, err := svc.RebuildBalances(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, repaired)
+	assert.Equal(t, 1000, mock.users[user.ID].Coins)
+}
+
+func TestService_Reconcile(t *testing.T) {
+	ctx := context.Background()
+	mock := newMockRepo()
+	svc := NewService(mock, testConfig)
+
+	ziyo, _ := svc.RegisterOrLogin(ctx, "Ziyo", "Valid@Pass123")
+	ali, _ := svc.RegisterOrLogin(ctx, "Ali", "Valid@Pass123")
+	mock.users[ziyo.ID].Coins = 1500
+	mock.drift = []domain.BalanceDrift{{UserID: ziyo.ID, Username: "Ziyo", Cached: 1500, Ledger: 1000}}
+	mock.balances = []domain.UserBalance{
+		{UserID: ziyo.ID, Username: "Ziyo", Cached: 1500, Ledger: 1000, History: 1000},
+		{UserID: ali.ID, Username: "Ali", Cached: 1000, Ledger: 1000, History: 1000},
+	}
+
+	report, err := svc.Reconcile(ctx, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, report.UsersChecked)
+	assert.Len(t, report.Discrepancies, 1)
+	assert.False(t, report.Discrepancies[0].Repaired)
+	assert.False(t, report.Consistent())
+	assert.Equal(t, 1500, mock.users[ziyo.ID].Coins, "dry run must not repair")
+
+	// Rebuilding a wallet that has no ledger account would zero it.
+	mock.missingAccounts = 1
+	_, err = svc.Reconcile(ctx, true)
+	assert.ErrorIs(t, err, domain.ErrMissingLedgerAccount)
+	assert.Equal(t, 1500, mock.users[ziyo.ID].Coins)
+	mock.missingAccounts = 0
+
+	report, err = svc.Reconcile(ctx, true)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Repaired)
+	assert.True(t, report.Discrepancies[0].Repaired)
+	assert.True(t, report.Consistent())
+	assert.Equal(t, 1000, mock.users[ziyo.ID].Coins)
+
+	// A ledger/history mismatch cannot be repaired automatically.
+	mock.balances = []domain.UserBalance{
+		{UserID: ali.ID, Username: "Ali", Cached: 1000, Ledger: 1000, History: 900},
+	}
+	report, err = svc.Reconcile(ctx, true)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, report.Repaired)
+	assert.False(t, report.Consistent())
+}
+
+func TestService_ListTransactions(t *testing.T) {
+	ctx := context.Background()
+	mock := newMockRepo()
+	svc := NewService(mock, testConfig)
+
+	ziyo, _ := svc.RegisterOrLogin(ctx, "Ziyo", "Valid@Pass123")
+	_, _ = svc.RegisterOrLogin(ctx, "Ali", "Valid@Pass123")
+	_, _ = svc.RegisterOrLogin(ctx, "Bek", "Valid@Pass123")
+
+	for i := 1; i <= 5; i++ {
+		assert.NoError(t, svc.SendCoin(ctx, ziyo.ID, "Ali", i, ""))
+	}
+	assert.NoError(t, svc.SendCoin(ctx, ziyo.ID, "Bek", 10, ""))
+	bek, _ := mock.GetUserByUsername(ctx, "Bek")
+	assert.NoError(t, svc.SendCoin(ctx, bek.ID, "Ziyo", 7, ""))
+
+	page, err := svc.ListTransactions(ctx, ziyo.ID, TransactionQuery{Limit: 3})
+	assert.NoError(t, err)
+	assert.Len(t, page.Transactions, 3)
+	assert.Equal(t, domain.DirectionReceived, page.Transactions[0].Direction)
+	assert.Equal(t, "Bek", page.Transactions[0].Counterparty)
+	assert.NotEmpty(t, page.NextCursor)
+
+	var all []TransactionEntry
+	all = append(all, page.Transactions...)
+	for page.NextCursor != "" {
+		page, err = svc.ListTransactions(ctx, ziyo.ID, TransactionQuery{Limit: 3, Cursor: page.NextCursor})
+		assert.NoError(t, err)
+		all = append(all, page.Transactions...)
+	}
+	assert.Len(t, all, 7)
+
+	page, err = svc.ListTransactions(ctx, ziyo.ID, TransactionQuery{Direction: "sent", Counterparty: "Ali"})
+	assert.NoError(t, err)
+	assert.Len(t, page.Transactions, 5)
+	assert.Empty(t, page.NextCursor)
+
+	page, err = svc.ListTransactions(ctx, ziyo.ID, TransactionQuery{From: time.Now().Add(time.Hour)})
+	assert.NoError(t, err)
+	assert.Len(t, page.Transactions, 0)
+
+	_, err = svc.ListTransactions(ctx, ziyo.ID, TransactionQuery{Direction: "sideways"})
+	assert.Equal(t, ErrInvalidDirection, err)
+	_, err = svc.ListTransactions(ctx, ziyo.ID, TransactionQuery{Cursor: "!!"})
+	assert.Equal(t, ErrInvalidCursor, err)
+	_, err = svc.ListTransactions(ctx, ziyo.ID, TransactionQuery{Counterparty: "Nobody"})
+	assert.Equal(t, ErrUnknownCounterparty, err)
+}
+
+func TestService_SendCoin_Memo(t *testing.T) {
+	ctx := context.Background()
+	mock := newMockRepo()
+	svc := NewService(mock, testConfig)
+
+	ziyo, _ := svc.RegisterOrLogin(ctx, "Ziyo", "Valid@Pass123")
+	ali, _ := svc.RegisterOrLogin(ctx, "Ali", "Valid@Pass123")
+
+	err := svc.SendCoin(ctx, ziyo.ID, "Ali", 10, strings.Repeat("a", maxMemoLength+1))
+	assert.Equal(t, ErrMemoTooLong, err)
+
+	err = svc.SendCoin(ctx, ziyo.ID, "Ali", 10, "  thanks\u202e for\x00 the\n\nreview \u200b ")
+	assert.NoError(t, err)
+
+	infoAli, _ := svc.GetInfo(ctx, ali.ID)
+	assert.Equal(t, "thanks for the review", infoAli.CoinHistory.Received[0].Memo)
+	infoZiyo, _ := svc.GetInfo(ctx, ziyo.ID)
+	assert.Equal(t, "thanks for the review", infoZiyo.CoinHistory.Sent[0].Memo)
+
+	page, _ := svc.ListTransactions(ctx, ali.ID, TransactionQuery{})
+	assert.Equal(t, "thanks for the review", page.Transactions[0].Memo)
+}
+
+func TestService_RefreshSession(t *testing.T) {
+	ctx := context.Background()
+	mock := newMockRepo()
+	svc := NewService(mock, testConfig)
+
+	ziyo, _ := svc.RegisterOrLogin(ctx, "Ziyo", "Valid@Pass123")
+	first, err := svc.IssueRefreshToken(ctx, ziyo.ID)
+	assert.NoError(t, err)
+
+	user, second, err := svc.RefreshSession(ctx, first)
+	assert.NoError(t, err)
+	assert.Equal(t, ziyo.ID, user.ID)
+	assert.NotEqual(t, first, second)
+
+	_, _, err = svc.RefreshSession(ctx, "garbage")
+	assert.Equal(t, ErrInvalidRefreshToken, err)
+
+	// Replaying a rotated token revokes the family, including the newest token.
+	_, _, err = svc.RefreshSession(ctx, first)
+	assert.Equal(t, ErrInvalidRefreshToken, err)
+	_, _, err = svc.RefreshSession(ctx, second)
+	assert.Equal(t, ErrInvalidRefreshToken, err)
+
+	expired, _ := svc.IssueRefreshToken(ctx, ziyo.ID)
+	mock.refresh[len(mock.refresh)-1].ExpiresAt = time.Now().Add(-time.Minute)
+	_, _, err = svc.RefreshSession(ctx, expired)
+	assert.Equal(t, ErrInvalidRefreshToken, err)
+}
+
+func TestService_Logout(t *testing.T) {
+	ctx := context.Background()
+	mock := newMockRepo()
+	svc := NewService(mock, testConfig)
+
+	ziyo, _ := svc.RegisterOrLogin(ctx, "Ziyo", "Valid@Pass123")
+	ali, _ := svc.RegisterOrLogin(ctx, "Ali", "Valid@Pass123")
+	ziyoRefresh, _ := svc.IssueRefreshToken(ctx, ziyo.ID)
+	aliRefresh, _ := svc.IssueRefreshToken(ctx, ali.ID)
+
+	assert.NoError(t, validateErr(svc.ValidateAccessToken(ctx, ziyo.ID, "jti-1")))
+	assert.NoError(t, svc.Logout(ctx, ziyo.ID, "jti-1", time.Now().Add(time.Minute), ziyoRefresh))
+	assert.Equal(t, ErrTokenRevoked, validateErr(svc.ValidateAccessToken(ctx, ziyo.ID, "jti-1")))
+
+	_, _, err := svc.RefreshSession(ctx, ziyoRefresh)
+	assert.Equal(t, ErrInvalidRefreshToken, err)
+
+	// Someone else's refresh token is not touched.
+	assert.NoError(t, svc.Logout(ctx, ziyo.ID, "jti-2", time.Now().Add(time.Minute), aliRefresh))
+	_, _, err = svc.RefreshSession(ctx, aliRefresh)
+	assert.NoError(t, err)
+}
+
+func TestService_SetUserRole(t *testing.T) {
+	ctx := context.Background()
+	mock := newMockRepo()
+	svc := NewService(mock, testConfig)
+
+	admin, _ := svc.Register(ctx, "Admin", "Valid@Pass123", "")
+	ali, _ := svc.Register(ctx, "Ali", "Valid@Pass123", "")
+	assert.Equal(t, domain.RoleEmployee, ali.Role)
+
+	assert.NoError(t, svc.SetUserRole(ctx, admin.ID, "Ali", domain.RoleManager))
+	assert.Equal(t, domain.RoleManager, mock.users[ali.ID].Role)
+	assert.True(t, mock.users[ali.ID].Role.Can(domain.PermManageCatalog))
+	assert.False(t, mock.users[ali.ID].Role.Can(domain.PermManageUsers))
+
+	assert.Equal(t, ErrInvalidRole, svc.SetUserRole(ctx, admin.ID, "Ali", "root"))
+	assert.Equal(t, ErrUserNotFound, svc.SetUserRole(ctx, admin.ID, "Nobody", domain.RoleAdmin))
+	assert.Equal(t, ErrChangeOwnRole, svc.SetUserRole(ctx, admin.ID, "Admin", domain.RoleEmployee))
+
+	role, err := svc.ValidateAccessToken(ctx, ali.ID, "jti")
+	assert.NoError(t, err)
+	assert.Equal(t, domain.RoleManager, role)
+	assert.NoError(t, svc.SetUserRole(ctx, admin.ID, "Ali", domain.RoleEmployee))
+	role, err = svc.ValidateAccessToken(ctx, ali.ID, "jti")
+	assert.NoError(t, err)
+	assert.Equal(t, domain.RoleEmployee, role, "a demotion applies to tokens already issued")
+}
+
+// validateErr drops the role returned by ValidateAccessToken.
+func validateErr(_ domain.Role, err error) error {
+	return err
+}
+
+func TestService_GrantCoins(t *testing.T) {
+	ctx := context.Background()
+	mock := newMockRepo()
+	svc := NewService(mock, testConfig)
+
+	admin, _ := svc.Register(ctx, "Admin", "Valid@Pass123", "")
+	ziyo, _ := svc.Register(ctx, "Ziyo", "Valid@Pass123", "")
+	ali, _ := svc.Register(ctx, "Ali", "Valid@Pass123", "")
+
+	_, err := svc.GrantCoins(ctx, admin.ID, nil)
+	assert.Equal(t, ErrEmptyGrant, err)
+	_, err = svc.GrantCoins(ctx, admin.ID, []GrantRequest{{Username: "Ziyo", Amount: 100}})
+	assert.Equal(t, ErrReasonRequired, err)
+	_, err = svc.GrantCoins(ctx, admin.ID, []GrantRequest{{Username: "Ziyo", Amount: -5, Reason: "oops"}})
+	assert.Equal(t, ErrInvalidGrantAmount, err)
+
+	// One unknown recipient fails the whole batch.
+	_, err = svc.GrantCoins(ctx, admin.ID, []GrantRequest{
+		{Username: "Ziyo", Amount: 100, Reason: "hackathon"},
+		{Username: "Nobody", Amount: 100, Reason: "hackathon"},
+	})
+	assert.ErrorIs(t, err, ErrUnknownRecipient)
+	assert.Contains(t, err.Error(), "Nobody")
+	assert.Equal(t, 1000, mock.users[ziyo.ID].Coins)
+
+	grants, err := ParseGrantsCSV(strings.NewReader("username,amount,reason\nZiyo,300,  Q3 review \nAli,150\n"), "hackathon")
+	assert.NoError(t, err)
+	res, err := svc.GrantCoins(ctx, admin.ID, grants)
+	assert.NoError(t, err)
+	assert.Equal(t, &GrantResponse{Recipients: 2, Total: 450}, res)
+	assert.Equal(t, 1300, mock.users[ziyo.ID].Coins)
+	assert.Equal(t, 1150, mock.users[ali.ID].Coins)
+
+	info, _ := svc.GetInfo(ctx, ziyo.ID)
+	assert.Equal(t, "system", info.CoinHistory.Received[0].FromUser)
+	assert.Equal(t, "Q3 review", info.CoinHistory.Received[0].Memo)
+	page, _ := svc.ListTransactions(ctx, ali.ID, TransactionQuery{})
+	assert.Equal(t, "system", page.Transactions[0].Counterparty)
+	assert.Equal(t, "hackathon", page.Transactions[0].Memo)
+
+	_, err = ParseGrantsCSV(strings.NewReader("Ziyo,lots\n"), "x")
+	assert.ErrorIs(t, err, ErrInvalidGrantCSV)
+	_, err = svc.Register(ctx, "System", "Valid@Pass123", "")
+	assert.Equal(t, ErrInvalidUsername, err)
+}
+
+func TestService_SignupBonusAndAllowance(t *testing.T) {
+	ctx := context.Background()
+	mock := newMockRepo()
+	cfg := testConfig
+	cfg.SignupBonus = 250
+	svc := NewService(mock, cfg)
+
+	ziyo, _ := svc.Register(ctx, "Ziyo", "Valid@Pass123", "")
+	assert.Equal(t, 250, ziyo.Coins)
+
+	paid, err := svc.PayAllowance(ctx, "2024-05")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, paid, "allowance is disabled by default")
+
+	cfg.AllowanceAmount = 100
+	svc = NewService(mock, cfg)
+	ali, _ := svc.Register(ctx, "Ali", "Valid@Pass123", "")
+
+	paid, _ = svc.PayAllowance(ctx, "2024-05")
+	assert.Equal(t, 2, paid)
+	paid, _ = svc.PayAllowance(ctx, "2024-05")
+	assert.Equal(t, 0, paid, "a period is paid only once")
+	paid, _ = svc.PayAllowance(ctx, "2024-06")
+	assert.Equal(t, 2, paid)
+
+	assert.Equal(t, 450, mock.users[ziyo.ID].Coins)
+	assert.Equal(t, 450, mock.users[ali.ID].Coins)
+	info, _ := svc.GetInfo(ctx, ali.ID)
+	assert.Equal(t, "allowance 2024-06", info.CoinHistory.Received[1].Memo)
+}
+
+func TestService_CoinExpiry(t *testing.T) {
+	ctx := context.Background()
+	mock := newMockRepo()
+	svc := NewService(mock, testConfig)
+
+	ziyo, _ := svc.Register(ctx, "Ziyo", "Valid@Pass123", "")
+	now := time.Now()
+	mock.lots = []domain.CoinLot{
+		{ID: 1, UserID: ziyo.ID, Amount: 1000, Remaining: 400, CreatedAt: now.AddDate(0, -13, 0)},
+		{ID: 2, UserID: ziyo.ID, Amount: 300, Remaining: 300, CreatedAt: now.AddDate(0, -11, -20)},
+		{ID: 3, UserID: ziyo.ID, Amount: 300, Remaining: 300, CreatedAt: now.AddDate(0, -1, 0)},
+	}
+
+	expired, err := svc.ExpireCoins(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, expired, "expiry is disabled without a lifetime")
+	info, _ := svc.GetInfo(ctx, ziyo.ID)
+	assert.Empty(t, info.ExpiringCoins)
+
+	cfg := testConfig
+	cfg.CoinLifetime = 365 * 24 * time.Hour
+	svc = NewService(mock, cfg)
+
+	info, _ = svc.GetInfo(ctx, ziyo.ID)
+	assert.Len(t, info.ExpiringCoins, 2, "only lots expiring within the notice period are shown")
+	assert.Equal(t, 400, info.ExpiringCoins[0].Amount)
+	assert.Equal(t, 300, info.ExpiringCoins[1].Amount)
+	assert.WithinDuration(t, mock.lots[1].CreatedAt.Add(cfg.CoinLifetime), info.ExpiringCoins[1].ExpiresAt, 0)
+
+	expired, err = svc.ExpireCoins(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 400, expired)
+	assert.WithinDuration(t, now.Add(-cfg.CoinLifetime), mock.expiredUpTo, time.Minute)
+	assert.Equal(t, 600, mock.users[ziyo.ID].Coins)
+}
+
+func TestService_FreezeUser(t *testing.T) {
+	ctx := context.Background()
+	mock := newMockRepo()
+	svc := NewService(mock, testConfig)
+
+	admin, _ := svc.Register(ctx, "Admin", "Valid@Pass123", "")
+	ziyo, _ := svc.Register(ctx, "Ziyo", "Valid@Pass123", "")
+	_, _ = svc.Register(ctx, "Ali", "Valid@Pass123", "")
+	refresh, _ := svc.IssueRefreshToken(ctx, ziyo.ID)
+	assert.NoError(t, svc.BuyMerch(ctx, ziyo.ID, "pen"))
+	purchaseID := mock.purchases[0].ID
+
+	assert.Equal(t, ErrFreezeSelf, svc.SetUserStatus(ctx, admin.ID, "Admin", domain.StatusFrozen))
+	assert.Equal(t, ErrUserNotFound, svc.SetUserStatus(ctx, admin.ID, "Nobody", domain.StatusFrozen))
+	assert.NoError(t, svc.SetUserStatus(ctx, admin.ID, "Ziyo", domain.StatusFrozen))
+
+	_, err := svc.Login(ctx, "Ziyo", "Valid@Pass123")
+	assert.Equal(t, ErrAccountFrozen, err)
+	_, _, err = svc.RefreshSession(ctx, refresh)
+	assert.Equal(t, ErrAccountFrozen, err)
+	assert.Equal(t, ErrAccountFrozen, validateErr(svc.ValidateAccessToken(ctx, ziyo.ID, "jti")))
+
+	assert.Equal(t, ErrAccountFrozen, svc.SendCoin(ctx, ziyo.ID, "Ali", 10, ""))
+	assert.Equal(t, ErrAccountFrozen, svc.BuyMerch(ctx, ziyo.ID, "pen"))
+	assert.Equal(t, ErrRecipientFrozen, svc.SendCoin(ctx, admin.ID, "Ziyo", 10, ""))
+	_, err = svc.GrantCoins(ctx, admin.ID, []GrantRequest{{Username: "Ziyo", Amount: 10, Reason: "bonus"}})
+	assert.ErrorIs(t, err, ErrRecipientFrozen)
+	_, err = svc.RefundPurchase(ctx, ziyo.ID, purchaseID, 0)
+	assert.Equal(t, ErrAccountFrozen, err)
+	_, err = svc.AdminRefundPurchase(ctx, admin.ID, purchaseID, 0)
+	assert.Equal(t, ErrAccountFrozen, err, "an admin refund must not credit a frozen account either")
+	assert.Equal(t, 990, mock.users[ziyo.ID].Coins)
+	assert.Empty(t, mock.refunds)
+
+	assert.NoError(t, svc.SetUserStatus(ctx, admin.ID, "Ziyo", domain.StatusActive))
+	assert.NoError(t, validateErr(svc.ValidateAccessToken(ctx, ziyo.ID, "jti")))
+	assert.NoError(t, svc.SendCoin(ctx, ziyo.ID, "Ali", 10, ""))
+}
+
+func TestService_DeleteAccount(t *testing.T) {
+	ctx := context.Background()
+	mock := newMockRepo()
+	svc := NewService(mock, testConfig)
+
+	admin, _ := svc.Register(ctx, "Admin", "Valid@Pass123", "")
+	ziyo, _ := svc.Register(ctx, "Ziyo", "Valid@Pass123", "")
+	ali, _ := svc.Register(ctx, "Ali", "Valid@Pass123", "")
+	assert.NoError(t, svc.SendCoin(ctx, ziyo.ID, "Ali", 100, "thanks"))
+	assert.NoError(t, svc.SendCoin(ctx, ali.ID, "Ziyo", 50, ""))
+
+	assert.Equal(t, ErrInvalidCredentials, svc.DeleteAccount(ctx, ziyo.ID, "Wrong@Pass123"))
+	assert.NoError(t, svc.DeleteAccount(ctx, ziyo.ID, "Valid@Pass123"))
+	assert.Equal(t, ErrUserNotFound, svc.DeleteAccount(ctx, ziyo.ID, "Valid@Pass123"))
+
+	info, err := svc.GetInfo(ctx, ali.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "deleted user", info.CoinHistory.Received[0].FromUser)
+	assert.Equal(t, "thanks", info.CoinHistory.Received[0].Memo)
+	assert.Equal(t, "deleted user", info.CoinHistory.Sent[0].ToUser)
+	page, _ := svc.ListTransactions(ctx, ali.ID, TransactionQuery{})
+	assert.Equal(t, "deleted user", page.Transactions[0].Counterparty)
+
+	_, err = svc.Login(ctx, "Ziyo", "Valid@Pass123")
+	assert.Equal(t, ErrInvalidCredentials, err)
+	assert.Equal(t, ErrUserNotFound, validateErr(svc.ValidateAccessToken(ctx, ziyo.ID, "")))
+	assert.Error(t, svc.SendCoin(ctx, ali.ID, mock.users[ziyo.ID].Username, 10, ""))
+	_, err = svc.Register(ctx, mock.users[ziyo.ID].Username, "Valid@Pass123", "")
+	assert.Equal(t, ErrInvalidUsername, err)
+
+	assert.Equal(t, ErrDeleteSelf, svc.DeleteUser(ctx, admin.ID, "Admin"))
+	assert.Equal(t, domain.StatusActive, mock.users[admin.ID].Status)
+	assert.NoError(t, svc.DeleteUser(ctx, admin.ID, "Ali"))
+	assert.Equal(t, domain.StatusDeleted, mock.users[ali.ID].Status)
+	assert.Equal(t, ErrUserNotFound, svc.DeleteUser(ctx, admin.ID, "Ali"))
+}
+
+func TestService_BusinessMetrics(t *testing.T) {
+	ctx := context.Background()
+	mock := newMockRepo()
+	svc := NewService(mock, testConfig)
+
+	registrations := testutil.ToFloat64(metrics.Registrations)
+	transferred := testutil.ToFloat64(metrics.CoinsTransferred)
+	pens := testutil.ToFloat64(metrics.ItemsPurchased.WithLabelValues("pen"))
+	unknown := testutil.ToFloat64(metrics.PurchaseFailures.WithLabelValues("unknown_item"))
+
+	ziyo, _ := svc.Register(ctx, "Ziyo", "Valid@Pass123", "")
+	_, _ = svc.Register(ctx, "Ali", "Valid@Pass123", "")
+	assert.Equal(t, registrations+2, testutil.ToFloat64(metrics.Registrations))
+
+	assert.NoError(t, svc.SendCoin(ctx, ziyo.ID, "Ali", 30, ""))
+	assert.Equal(t, transferred+30, testutil.ToFloat64(metrics.CoinsTransferred))
+
+	_, err := svc.PlaceOrder(ctx, ziyo.ID, []OrderItem{{Item: "pen", Quantity: 3}})
+	assert.NoError(t, err)
+	assert.Equal(t, pens+3, testutil.ToFloat64(metrics.ItemsPurchased.WithLabelValues("pen")))
+
+	assert.Equal(t, ErrUnknownItem, svc.BuyMerch(ctx, ziyo.ID, "yacht"))
+	assert.Equal(t, unknown+1, testutil.ToFloat64(metrics.PurchaseFailures.WithLabelValues("unknown_item")))
+}
+
+func TestService_Readiness(t *testing.T) {
+	ctx := context.Background()
+	mock := newMockRepo()
+	svc := NewService(mock, testConfig)
+
+	checks := svc.Readiness(ctx)
+	assert.NoError(t, checks["database"])
+	assert.NoError(t, checks["schema"])
+
+	mock.schemaErr = errors.New("missing tables: coin_lots")
+	assert.EqualError(t, svc.Readiness(ctx)["schema"], "missing tables: coin_lots")
+
+	mock.pingErr = errors.New("connection refused")
+	checks = svc.Readiness(ctx)
+	assert.Error(t, checks["database"])
+	_, checked := checks["schema"]
+	assert.False(t, checked, "schema is not checked without a database")
+}
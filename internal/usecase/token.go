@@ -0,0 +1,174 @@
+package usecase
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"merchShop/internal/domain"
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	ErrTokenRevoked        = errors.New("token has been revoked")
+)
+
+const refreshTokenBytes = 32
+
+// IssueRefreshToken starts a new refresh token family for a fresh login and
+// returns the plain token. Only its hash is stored.
+func (s *Service) IssueRefreshToken(ctx context.Context, userID int) (_ string, err error) {
+	ctx, span := tracer.Start(ctx, "Service.IssueRefreshToken")
+	defer func() { endSpan(span, err) }()
+	family, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	return s.storeRefreshToken(ctx, userID, family)
+}
+
+// RefreshSession exchanges a refresh token for a new one from the same family
+// and returns the user the new access token should be issued for. Presenting
+// a token that was already rotated revokes the whole family, since either the
+// client or an attacker holds a stolen copy.
+func (s *Service) RefreshSession(ctx context.Context, refreshToken string) (_ *domain.User, _ string, err error) {
+	ctx, span := tracer.Start(ctx, "Service.RefreshSession")
+	defer func() { endSpan(span, err) }()
+	if refreshToken == "" {
+		return nil, "", ErrInvalidRefreshToken
+	}
+	stored, err := s.repo.GetRefreshToken(ctx, hashToken(refreshToken))
+	if err != nil {
+		return nil, "", err
+	}
+	if stored == nil || !time.Now().Before(stored.ExpiresAt) {
+		return nil, "", ErrInvalidRefreshToken
+	}
+	if stored.RevokedAt != nil {
+		if err := s.repo.RevokeRefreshTokenFamily(ctx, stored.FamilyID); err != nil {
+			return nil, "", err
+		}
+		return nil, "", ErrInvalidRefreshToken
+	}
+
+	user, err := s.repo.GetUserByID(ctx, stored.UserID)
+	if err != nil {
+		return nil, "", err
+	}
+	if user == nil || user.Status == domain.StatusDeleted {
+		return nil, "", ErrInvalidRefreshToken
+	}
+	if user.Status == domain.StatusFrozen {
+		return nil, "", ErrAccountFrozen
+	}
+
+	plain, err := randomToken()
+	if err != nil {
+		return nil, "", err
+	}
+	next := domain.RefreshToken{
+		UserID:    stored.UserID,
+		TokenHash: hashToken(plain),
+		FamilyID:  stored.FamilyID,
+		ExpiresAt: time.Now().Add(s.cfg.RefreshTokenTTL),
+	}
+	if err := s.repo.RotateRefreshToken(ctx, stored.ID, next); err != nil {
+		if errors.Is(err, domain.ErrRefreshTokenReused) {
+			if err := s.repo.RevokeRefreshTokenFamily(ctx, stored.FamilyID); err != nil {
+				return nil, "", err
+			}
+			return nil, "", ErrInvalidRefreshToken
+		}
+		return nil, "", err
+	}
+	return user, plain, nil
+}
+
+// Logout revokes the access token identified by jti and, if given, the
+// refresh token family it was issued with. A refresh token belonging to
+// another user is ignored rather than reported.
+func (s *Service) Logout(ctx context.Context, userID int, jti string, expiresAt time.Time, refreshToken string) (err error) {
+	ctx, span := tracer.Start(ctx, "Service.Logout")
+	defer func() { endSpan(span, err) }()
+	if jti != "" {
+		if err := s.repo.RevokeAccessToken(ctx, jti, expiresAt); err != nil {
+			return err
+		}
+	}
+	if refreshToken == "" {
+		return nil
+	}
+	stored, err := s.repo.GetRefreshToken(ctx, hashToken(refreshToken))
+	if err != nil {
+		return err
+	}
+	if stored == nil || stored.UserID != userID {
+		return nil
+	}
+	return s.repo.RevokeRefreshTokenFamily(ctx, stored.FamilyID)
+}
+
+// ValidateAccessToken is called by the JWT middleware for every request. It
+// rejects revoked tokens and tokens of frozen accounts, and returns the user's
+// current role so a demotion takes effect before the token expires. Tokens
+// issued before revocation support carry no jti and cannot be revoked
+// individually.
+func (s *Service) ValidateAccessToken(ctx context.Context, userID int, jti string) (_ domain.Role, err error) {
+	ctx, span := tracer.Start(ctx, "Service.ValidateAccessToken")
+	defer func() { endSpan(span, err) }()
+	if jti != "" {
+		revoked, err := s.repo.IsAccessTokenRevoked(ctx, jti)
+		if err != nil {
+			return "", err
+		}
+		if revoked {
+			return "", ErrTokenRevoked
+		}
+	}
+	user, err := s.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	if user == nil || user.Status == domain.StatusDeleted {
+		return "", ErrUserNotFound
+	}
+	if user.Status == domain.StatusFrozen {
+		return "", ErrAccountFrozen
+	}
+	return user.Role, nil
+}
+
+func (s *Service) storeRefreshToken(ctx context.Context, userID int, family string) (string, error) {
+	plain, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	err = s.repo.CreateRefreshToken(ctx, domain.RefreshToken{
+		UserID:    userID,
+		TokenHash: hashToken(plain),
+		FamilyID:  family,
+		ExpiresAt: time.Now().Add(s.cfg.RefreshTokenTTL),
+	})
+	if err != nil {
+		return "", err
+	}
+	return plain, nil
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, refreshTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
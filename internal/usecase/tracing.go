@@ -0,0 +1,24 @@
+package usecase
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer starts one span per exported Service method. It resolves to the
+// global provider lazily, so tracing.Setup may run after NewService.
+var tracer = otel.Tracer("merchShop/internal/usecase")
+
+// endSpan marks span as failed when err is set and ends it. Methods defer it
+// with their named error result:
+//
+//	ctx, span := tracer.Start(ctx, "Service.X")
+//	defer func() { endSpan(span, err) }()
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,149 @@
+package usecase
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"merchShop/internal/domain"
+)
+
+const (
+	defaultHistoryLimit = 20
+	maxHistoryLimit     = 100
+)
+
+var (
+	ErrInvalidDirection    = errors.New("direction must be sent or received")
+	ErrInvalidCursor       = errors.New("invalid cursor")
+	ErrInvalidLimit        = fmt.Errorf("limit must be between 1 and %d", maxHistoryLimit)
+	ErrInvalidDateRange    = errors.New("from must be before to")
+	ErrUnknownCounterparty = errors.New("counterparty not found")
+)
+
+// TransactionQuery is the user-facing filter of GET /api/transactions.
+type TransactionQuery struct {
+	Direction    string
+	Counterparty string
+	From         time.Time
+	To           time.Time
+	Cursor       string
+	Limit        int
+}
+
+type TransactionEntry struct {
+	ID           int       `json:"id"`
+	Direction    string    `json:"direction"`
+	Counterparty string    `json:"counterparty"`
+	Amount       int       `json:"amount"`
+	Memo         string    `json:"memo,omitempty"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+type TransactionsPage struct {
+	Transactions []TransactionEntry `json:"transactions"`
+	// NextCursor is empty on the last page.
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+func (s *Service) ListTransactions(ctx context.Context, userID int, q TransactionQuery) (_ *TransactionsPage, err error) {
+	ctx, span := tracer.Start(ctx, "Service.ListTransactions")
+	defer func() { endSpan(span, err) }()
+	f := domain.TransactionFilter{
+		UserID: userID,
+		From:   q.From,
+		To:     q.To,
+		Limit:  q.Limit,
+	}
+	switch q.Direction {
+	case "", domain.DirectionSent, domain.DirectionReceived:
+		f.Direction = q.Direction
+	default:
+		return nil, ErrInvalidDirection
+	}
+	if f.Limit == 0 {
+		f.Limit = defaultHistoryLimit
+	}
+	if f.Limit < 0 || f.Limit > maxHistoryLimit {
+		return nil, ErrInvalidLimit
+	}
+	if !f.From.IsZero() && !f.To.IsZero() && !f.From.Before(f.To) {
+		return nil, ErrInvalidDateRange
+	}
+	if q.Cursor != "" {
+		id, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		f.BeforeID = id
+	}
+	if q.Counterparty != "" {
+		c, err := s.repo.GetUserByUsername(ctx, q.Counterparty)
+		if err != nil {
+			return nil, err
+		}
+		if c == nil {
+			return nil, ErrUnknownCounterparty
+		}
+		f.CounterpartyID = c.ID
+	}
+
+	// One extra row tells whether there is a next page.
+	limit := f.Limit
+	f.Limit++
+	txs, err := s.repo.ListTransactions(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &TransactionsPage{Transactions: make([]TransactionEntry, 0, limit)}
+	if len(txs) > limit {
+		txs = txs[:limit]
+		page.NextCursor = encodeCursor(txs[limit-1].ID)
+	}
+
+	names := make(map[int]string)
+	for _, tx := range txs {
+		e := TransactionEntry{ID: tx.ID, Amount: tx.Amount, Memo: tx.Memo, CreatedAt: tx.CreatedAt}
+		otherID := tx.ToUserID
+		e.Direction = domain.DirectionSent
+		if tx.FromUserID != userID {
+			otherID = tx.FromUserID
+			e.Direction = domain.DirectionReceived
+		}
+		name, ok := names[otherID]
+		if otherID == 0 {
+			name, ok = systemCounterparty, true
+		}
+		if !ok {
+			other, err := s.repo.GetUserByID(ctx, otherID)
+			if err != nil {
+				return nil, err
+			}
+			name = counterpartyName(other)
+			names[otherID] = name
+		}
+		e.Counterparty = name
+		page.Transactions = append(page.Transactions, e)
+	}
+	return page, nil
+}
+
+func encodeCursor(id int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(id)))
+}
+
+func decodeCursor(cursor string) (int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, err
+	}
+	id, err := strconv.Atoi(string(raw))
+	if err != nil || id <= 0 {
+		return 0, ErrInvalidCursor
+	}
+	return id, nil
+}
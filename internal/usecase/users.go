@@ -0,0 +1,67 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"merchShop/internal/domain"
+)
+
+var (
+	ErrUserNotFound  = errors.New("user not found")
+	ErrInvalidRole   = errors.New("role must be one of: employee, manager, admin")
+	ErrChangeOwnRole = errors.New("you cannot change your own role")
+	ErrFreezeSelf    = errors.New("you cannot freeze your own account")
+	ErrDeleteSelf    = errors.New("you cannot delete your own account as an admin")
+)
+
+// SetUserRole changes the role of username. Admins cannot change their own
+// role, so the last admin cannot lock everyone out by accident. The new role
+// takes effect when the user's current access token expires.
+func (s *Service) SetUserRole(ctx context.Context, actorID int, username string, role domain.Role) (err error) {
+	ctx, span := tracer.Start(ctx, "Service.SetUserRole")
+	defer func() { endSpan(span, err) }()
+	if !role.Valid() {
+		return ErrInvalidRole
+	}
+	user, err := s.repo.GetUserByUsername(ctx, username)
+	if err != nil {
+		return err
+	}
+	if user == nil || user.Status == domain.StatusDeleted {
+		return ErrUserNotFound
+	}
+	if user.ID == actorID {
+		return ErrChangeOwnRole
+	}
+	if err := s.repo.SetUserRole(ctx, user.ID, role); err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "user role changed",
+		slog.Int("target_user_id", user.ID), slog.Int("actor_id", actorID), slog.String("role", string(role)))
+	return nil
+}
+
+// SetUserStatus freezes or unfreezes username. A frozen user is logged out
+// on their next request and cannot send, receive or buy.
+func (s *Service) SetUserStatus(ctx context.Context, actorID int, username string, status domain.UserStatus) (err error) {
+	ctx, span := tracer.Start(ctx, "Service.SetUserStatus")
+	defer func() { endSpan(span, err) }()
+	user, err := s.repo.GetUserByUsername(ctx, username)
+	if err != nil {
+		return err
+	}
+	if user == nil || user.Status == domain.StatusDeleted {
+		return ErrUserNotFound
+	}
+	if user.ID == actorID && status == domain.StatusFrozen {
+		return ErrFreezeSelf
+	}
+	if err := s.repo.SetUserStatus(ctx, user.ID, status); err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "user status changed",
+		slog.Int("target_user_id", user.ID), slog.Int("actor_id", actorID), slog.String("status", string(status)))
+	return nil
+}
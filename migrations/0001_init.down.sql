@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS user_inventory;
+DROP TABLE IF EXISTS coin_transactions;
+DROP TABLE IF EXISTS users;
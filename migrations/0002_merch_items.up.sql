@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS merch_items (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) UNIQUE NOT NULL,
+    price INT NOT NULL CHECK (price > 0),
+    description TEXT NOT NULL DEFAULT '',
+    stock INT NOT NULL DEFAULT 0 CHECK (stock >= 0),
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+    );
+
+INSERT INTO merch_items (name, price, stock) VALUES
+    ('t-shirt', 80, 100),
+    ('cup', 20, 100),
+    ('book', 50, 100),
+    ('pen', 10, 100),
+    ('powerbank', 200, 100),
+    ('hoody', 300, 100),
+    ('umbrella', 200, 100),
+    ('socks', 10, 100),
+    ('wallet', 50, 100),
+    ('pink-hoody', 500, 100)
+ON CONFLICT (name) DO NOTHING;
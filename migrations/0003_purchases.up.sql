@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS purchases (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id),
+    item_name VARCHAR(255) NOT NULL,
+    quantity INT NOT NULL CHECK (quantity > 0),
+    unit_price INT NOT NULL,
+    refunded_quantity INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    CHECK (refunded_quantity >= 0 AND refunded_quantity <= quantity)
+    );
+
+CREATE TABLE IF NOT EXISTS refunds (
+    id SERIAL PRIMARY KEY,
+    purchase_id INT NOT NULL REFERENCES purchases(id),
+    user_id INT NOT NULL REFERENCES users(id),
+    quantity INT NOT NULL CHECK (quantity > 0),
+    amount INT NOT NULL,
+    admin_override BOOLEAN NOT NULL DEFAULT FALSE,
+    refunded_by INT REFERENCES users(id),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+    );
+
+CREATE INDEX IF NOT EXISTS idx_purchases_user_id ON purchases(user_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_refunds_purchase_id ON refunds(purchase_id);
@@ -0,0 +1,8 @@
+ALTER TABLE refunds DROP COLUMN IF EXISTS ledger_entry_id;
+ALTER TABLE purchases DROP COLUMN IF EXISTS ledger_entry_id;
+ALTER TABLE coin_transactions DROP COLUMN IF EXISTS ledger_entry_id;
+
+DROP TABLE IF EXISTS ledger_postings;
+DROP FUNCTION IF EXISTS ledger_check_entry_balanced();
+DROP TABLE IF EXISTS ledger_entries;
+DROP TABLE IF EXISTS ledger_accounts;
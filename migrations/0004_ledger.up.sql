@@ -0,0 +1,80 @@
+-- Double-entry ledger. users.coins is a projection of the postings on the
+-- user's account and can be rebuilt from them.
+CREATE TABLE IF NOT EXISTS ledger_accounts (
+    id SERIAL PRIMARY KEY,
+    code VARCHAR(64) UNIQUE NOT NULL,
+    user_id INT UNIQUE REFERENCES users(id),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+    );
+
+INSERT INTO ledger_accounts (code) VALUES
+    ('system:issuance'),
+    ('shop:revenue')
+ON CONFLICT (code) DO NOTHING;
+
+CREATE TABLE IF NOT EXISTS ledger_entries (
+    id SERIAL PRIMARY KEY,
+    kind VARCHAR(32) NOT NULL,
+    actor_id INT REFERENCES users(id),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+    );
+
+CREATE TABLE IF NOT EXISTS ledger_postings (
+    id SERIAL PRIMARY KEY,
+    entry_id INT NOT NULL REFERENCES ledger_entries(id),
+    account_id INT NOT NULL REFERENCES ledger_accounts(id),
+    amount INT NOT NULL CHECK (amount <> 0)
+    );
+
+CREATE INDEX IF NOT EXISTS idx_ledger_postings_entry_id ON ledger_postings(entry_id);
+CREATE INDEX IF NOT EXISTS idx_ledger_postings_account_id ON ledger_postings(account_id);
+
+CREATE OR REPLACE FUNCTION ledger_check_entry_balanced() RETURNS trigger AS $$
+BEGIN
+    IF (SELECT COALESCE(SUM(amount), 0) FROM ledger_postings WHERE entry_id = NEW.entry_id) <> 0 THEN
+        RAISE EXCEPTION 'ledger entry % is not balanced', NEW.entry_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS ledger_postings_balanced ON ledger_postings;
+CREATE CONSTRAINT TRIGGER ledger_postings_balanced
+    AFTER INSERT OR UPDATE ON ledger_postings
+    DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW EXECUTE FUNCTION ledger_check_entry_balanced();
+
+ALTER TABLE coin_transactions ADD COLUMN IF NOT EXISTS ledger_entry_id INT REFERENCES ledger_entries(id);
+ALTER TABLE purchases ADD COLUMN IF NOT EXISTS ledger_entry_id INT REFERENCES ledger_entries(id);
+ALTER TABLE refunds ADD COLUMN IF NOT EXISTS ledger_entry_id INT REFERENCES ledger_entries(id);
+
+-- Wallets created before the ledger get their account and an opening signup
+-- entry from system:issuance for the balance they hold, so the ledger
+-- matches users.coins from the start.
+INSERT INTO ledger_accounts (code, user_id)
+SELECT 'user:' || u.id, u.id
+FROM users u
+WHERE NOT EXISTS (SELECT 1 FROM ledger_accounts a WHERE a.user_id = u.id);
+
+DO $$
+DECLARE
+    issuance INT;
+    wallet RECORD;
+    entry INT;
+BEGIN
+    SELECT id INTO issuance FROM ledger_accounts WHERE code = 'system:issuance';
+    FOR wallet IN
+        SELECT a.id AS account_id, u.coins
+        FROM users u
+        JOIN ledger_accounts a ON a.user_id = u.id
+        WHERE u.coins > 0
+          AND NOT EXISTS (SELECT 1 FROM ledger_postings p WHERE p.account_id = a.id)
+        ORDER BY u.id
+    LOOP
+        INSERT INTO ledger_entries (kind) VALUES ('signup') RETURNING id INTO entry;
+        INSERT INTO ledger_postings (entry_id, account_id, amount) VALUES
+            (entry, issuance, -wallet.coins),
+            (entry, wallet.account_id, wallet.coins);
+    END LOOP;
+END;
+$$;
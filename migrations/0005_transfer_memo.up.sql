@@ -0,0 +1 @@
+ALTER TABLE coin_transactions ADD COLUMN IF NOT EXISTS memo VARCHAR(200) NOT NULL DEFAULT '';
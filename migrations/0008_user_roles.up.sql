@@ -0,0 +1,2 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role VARCHAR(16) NOT NULL DEFAULT 'employee'
+    CHECK (role IN ('employee', 'manager', 'admin'));
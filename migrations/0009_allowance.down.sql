@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS allowance_payouts;
+ALTER TABLE users ALTER COLUMN coins SET DEFAULT 1000;
@@ -0,0 +1,13 @@
+-- The signup bonus is issued through the ledger, so new wallets start empty.
+ALTER TABLE users ALTER COLUMN coins SET DEFAULT 0;
+
+-- One row per user and allowance period, so a restarted scheduler never pays
+-- the same period twice.
+CREATE TABLE IF NOT EXISTS allowance_payouts (
+    period VARCHAR(32) NOT NULL,
+    user_id INT NOT NULL REFERENCES users(id),
+    amount INT NOT NULL,
+    ledger_entry_id INT REFERENCES ledger_entries(id),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (period, user_id)
+    );
@@ -0,0 +1,3 @@
+-- system:expired is kept: expiry entries may already post to it.
+DROP TABLE IF EXISTS coin_lot_spends;
+DROP TABLE IF EXISTS coin_lots;
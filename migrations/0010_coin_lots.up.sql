@@ -0,0 +1,35 @@
+INSERT INTO ledger_accounts (code) VALUES ('system:expired')
+ON CONFLICT (code) DO NOTHING;
+
+-- Coins are tracked in lots dated by when they were credited. Debits consume
+-- the oldest lots first and unspent lots expire after COIN_LIFETIME.
+CREATE TABLE IF NOT EXISTS coin_lots (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id),
+    amount INT NOT NULL CHECK (amount > 0),
+    remaining INT NOT NULL CHECK (remaining >= 0 AND remaining <= amount),
+    ledger_entry_id INT REFERENCES ledger_entries(id),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+    );
+
+CREATE INDEX IF NOT EXISTS idx_coin_lots_open ON coin_lots(user_id, created_at) WHERE remaining > 0;
+
+-- What each debit took from each lot. Coins passed on by a transfer or
+-- returned by a refund keep the date of the lot they were spent from.
+CREATE TABLE IF NOT EXISTS coin_lot_spends (
+    id SERIAL PRIMARY KEY,
+    ledger_entry_id INT NOT NULL REFERENCES ledger_entries(id),
+    lot_id INT NOT NULL REFERENCES coin_lots(id),
+    amount INT NOT NULL CHECK (amount > 0),
+    refunded INT NOT NULL DEFAULT 0 CHECK (refunded >= 0 AND refunded <= amount)
+    );
+
+CREATE INDEX IF NOT EXISTS idx_coin_lot_spends_entry_id ON coin_lot_spends(ledger_entry_id);
+
+-- Coins held before lots were tracked get one lot dated now, so their
+-- lifetime starts with this migration instead of never ending.
+INSERT INTO coin_lots (user_id, amount, remaining)
+SELECT u.id, u.coins - COALESCE(l.open, 0), u.coins - COALESCE(l.open, 0)
+FROM users u
+LEFT JOIN (SELECT user_id, SUM(remaining) AS open FROM coin_lots GROUP BY user_id) l ON l.user_id = u.id
+WHERE u.coins > COALESCE(l.open, 0);
@@ -0,0 +1,2 @@
+-- system:forfeited is kept: forfeit entries may already post to it.
+ALTER TABLE users DROP COLUMN IF EXISTS status;
@@ -0,0 +1,5 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS status VARCHAR(16) NOT NULL DEFAULT 'active'
+    CHECK (status IN ('active', 'frozen', 'deleted'));
+
+INSERT INTO ledger_accounts (code) VALUES ('system:forfeited')
+ON CONFLICT (code) DO NOTHING;
@@ -0,0 +1,9 @@
+// Package migrations embeds the numbered schema migrations into the binary.
+// Every change is a pair of files NNNN_name.up.sql and NNNN_name.down.sql;
+// applied files must never be edited, add a new version instead.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS